@@ -0,0 +1,126 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/svent/sift/search"
+)
+
+var selectionLogger = log.New(os.Stderr, "Selection: ", 0)
+
+// selectionFlag returns the command line option belonging to the filter
+// of a selection.
+func selectionFlag(filter string) string {
+	switch filter {
+	case "Recursive":
+		return "--no-recursive"
+	case "ExcludeDirs":
+		return "--exclude-dirs"
+	case "IncludeDirs":
+		return "--dirs"
+	case "Git":
+		return "--git"
+	case "GitUntracked":
+		return "--git-untracked"
+	case "FollowSymlinks":
+		return "--follow"
+	case "ExcludePath":
+		if options.ExcludeIPath != "" {
+			return "--exclude-ipath"
+		}
+		return "--exclude-path"
+	case "IncludePath":
+		if options.IncludeIPath != "" {
+			return "--ipath"
+		}
+		return "--path"
+	case "ExcludeExtensions":
+		return "--exclude-ext"
+	case "IncludeExtensions":
+		return "--ext"
+	case "ExcludeFiles":
+		return "--exclude-files"
+	case "IncludeFiles":
+		return "--files"
+	case "ExcludeTypes":
+		return "--no-type"
+	case "IncludeTypes":
+		return "--type"
+	}
+	return filter
+}
+
+// formatSelection returns a line describing the decision of a selection.
+func formatSelection(sel search.Selection) string {
+	path := sel.Path
+	if sel.IsDir {
+		path += string(filepath.Separator)
+	}
+	var res string
+	if sel.Selected {
+		res = path + ": selected"
+	} else if sel.Filter != "" {
+		res = path + ": skipped by " + selectionFlag(sel.Filter)
+	} else {
+		res = path + ": skipped"
+	}
+	if sel.Reason != "" {
+		res += ": " + sel.Reason
+	}
+	return res
+}
+
+// logSelection is used as selection handler for --debug-selection.
+func logSelection(sel search.Selection) {
+	selectionLogger.Println(formatSelection(sel))
+}
+
+// explainSelection prints the filter decisions for path when searching the
+// first of the targets that contains it. It returns whether path would be
+// searched.
+func explainSelection(path string, targets []string) (bool, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	for _, target := range targets {
+		absTarget, err := filepath.Abs(target)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(absTarget, absPath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		selections, err := global.searcher.Explain(target, path)
+		if err != nil {
+			return false, err
+		}
+		selected := true
+		for _, sel := range selections {
+			fmt.Fprintln(global.outputFile, formatSelection(sel))
+			selected = selected && sel.Selected
+		}
+		return selected, nil
+	}
+	return false, fmt.Errorf("'%s' is not within any of the targets", path)
+}
@@ -0,0 +1,147 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package git
+
+import "bytes"
+
+// maxDiffDistance limits the number of differing lines the diff algorithm
+// searches for. Beyond it, all lines between the common beginning and end
+// of the files are considered changed.
+const maxDiffDistance = 1000
+
+// LineRange is a range of lines, numbered from 1, including Start and End.
+type LineRange struct {
+	Start int64
+	End   int64
+}
+
+// ChangedLines compares two versions of a file line by line and returns the
+// ranges of lines of the new version that were added or modified. Lines that
+// were only removed are not reported.
+func ChangedLines(old []byte, new []byte) []LineRange {
+	a, b := lineIDs(old, new)
+
+	// lines at the beginning and the end that did not change
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	a = a[prefix : len(a)-suffix]
+	b = b[prefix : len(b)-suffix]
+
+	inserted := diffInsertions(a, b)
+	var ranges []LineRange
+	for i := 0; i < len(inserted); i++ {
+		if !inserted[i] {
+			continue
+		}
+		start := i
+		for i+1 < len(inserted) && inserted[i+1] {
+			i++
+		}
+		ranges = append(ranges, LineRange{int64(prefix + start + 1), int64(prefix + i + 1)})
+	}
+	return ranges
+}
+
+// lineIDs splits two files into lines and returns the lines as numbers,
+// equal lines having the same number.
+func lineIDs(old []byte, new []byte) ([]int, []int) {
+	ids := map[string]int{}
+	split := func(data []byte) []int {
+		lines := bytes.SplitAfter(data, []byte("\n"))
+		if len(lines[len(lines)-1]) == 0 {
+			lines = lines[:len(lines)-1]
+		}
+		result := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[string(line)]
+			if !ok {
+				id = len(ids)
+				ids[string(line)] = id
+			}
+			result[i] = id
+		}
+		return result
+	}
+	return split(old), split(new)
+}
+
+// diffInsertions finds a shortest edit script turning a into b with the
+// algorithm of Myers and returns for each element of b whether it was inserted.
+func diffInsertions(a []int, b []int) []bool {
+	inserted := make([]bool, len(b))
+	n, m := len(a), len(b)
+	max := n + m
+	if max > 2*maxDiffDistance {
+		max = 2 * maxDiffDistance
+	}
+
+	// v holds the furthest x reached on each diagonal k = x - y,
+	// trace the state of v before each round for the backtracking
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	distance := -1
+	for d := 0; d <= max && distance < 0; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				distance = d
+				break
+			}
+		}
+	}
+	if distance < 0 {
+		for i := range inserted {
+			inserted[i] = true
+		}
+		return inserted
+	}
+
+	x, y := n, m
+	for d := distance; d > 0; d-- {
+		prev := trace[d]
+		k := x - y
+		// the previous diagonal, a step down is an insertion, a step right a deletion
+		prevK := k - 1
+		if k == -d || (k != d && prev[k-1+d+1] < prev[k+1+d+1]) {
+			prevK = k + 1
+		}
+		x = prev[prevK+d+1]
+		y = x - prevK
+		if prevK == k+1 {
+			inserted[y] = true
+		}
+	}
+	return inserted
+}
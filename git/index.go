@@ -0,0 +1,203 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// file modes of index entries
+const (
+	ModeTypeMask = 0170000
+	ModeRegular  = 0100000
+	ModeSymlink  = 0120000
+	ModeGitlink  = 0160000
+	ModeDir      = 0040000
+)
+
+// index entry flags
+const (
+	indexFlagExtended     = 0x4000
+	indexFlagStageMask    = 0x3000
+	indexFlagStageShift   = 12
+	indexFlagNameMask     = 0x0fff
+	indexFlagSkipWorktree = 0x4000
+	indexFlagIntentToAdd  = 0x2000
+)
+
+var errIndexCorrupt = errors.New("index file corrupt")
+
+// IndexEntry is an entry of the git index.
+type IndexEntry struct {
+	// Path is the slash-separated path relative to the root of the work tree.
+	Path string
+	// Mode is the file mode, see ModeRegular, ModeSymlink and ModeGitlink
+	// (submodules). Sparse indexes contain entries of ModeDir.
+	Mode uint32
+	// Size is the size of the file (truncated to 32 bits).
+	Size uint32
+	// Hash is the name of the blob object.
+	Hash []byte
+	// Stage is 0 for normal entries and 1 to 3 for the versions of a
+	// file with merge conflicts.
+	Stage int
+	// SkipWorktree is set for files excluded by a sparse checkout.
+	SkipWorktree bool
+	// IntentToAdd is set for files added with "git add -N".
+	IntentToAdd bool
+	// ModTime is the modification time of the file when it was added.
+	ModTime time.Time
+
+	// racy is set if the file may have been modified after it was added,
+	// but within the resolution of ModTime
+	racy bool
+}
+
+// Index reads the index of the work tree. The entries are sorted by path and
+// stage. Versions 2 to 4 of the index format are supported, split indexes are not.
+func (r *Repository) Index() ([]IndexEntry, error) {
+	file := filepath.Join(r.GitDir, "index")
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseIndex(data, r.HashSize)
+	for i := range entries {
+		entries[i].racy = !entries[i].ModTime.Before(fi.ModTime())
+	}
+	return entries, err
+}
+
+// parseIndex parses the content of an index file.
+func parseIndex(data []byte, hashSize int) ([]IndexEntry, error) {
+	if len(data) < 12 || string(data[:4]) != "DIRC" {
+		return nil, errIndexCorrupt
+	}
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("unsupported index version %d", version)
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+	if uint64(count) > uint64(len(data)) {
+		return nil, errIndexCorrupt
+	}
+
+	// size of the fixed part of an entry: timestamps, stat data, hash and flags
+	fixedSize := 40 + hashSize + 2
+	entries := make([]IndexEntry, 0, count)
+	pos := 12
+	var name []byte
+	for i := uint32(0); i < count; i++ {
+		start := pos
+		if pos+fixedSize > len(data) {
+			return nil, errIndexCorrupt
+		}
+		e := IndexEntry{
+			ModTime: time.Unix(int64(binary.BigEndian.Uint32(data[pos+8:])), int64(binary.BigEndian.Uint32(data[pos+12:]))),
+			Mode:    binary.BigEndian.Uint32(data[pos+24 : pos+28]),
+			Size:    binary.BigEndian.Uint32(data[pos+36 : pos+40]),
+			Hash:    data[pos+40 : pos+40+hashSize],
+		}
+		flags := binary.BigEndian.Uint16(data[pos+40+hashSize:])
+		e.Stage = int(flags&indexFlagStageMask) >> indexFlagStageShift
+		pos += fixedSize
+		if flags&indexFlagExtended != 0 {
+			if version < 3 || pos+2 > len(data) {
+				return nil, errIndexCorrupt
+			}
+			extended := binary.BigEndian.Uint16(data[pos:])
+			e.SkipWorktree = extended&indexFlagSkipWorktree != 0
+			e.IntentToAdd = extended&indexFlagIntentToAdd != 0
+			pos += 2
+		}
+
+		if version == 4 {
+			// the name is stored as the number of bytes to remove from the end
+			// of the previous name followed by the suffix to append
+			strip, n := readOffset(data[pos:])
+			if n == 0 || strip > len(name) {
+				return nil, errIndexCorrupt
+			}
+			pos += n
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return nil, errIndexCorrupt
+			}
+			name = append(name[:len(name)-strip], data[pos:pos+end]...)
+			pos += end + 1
+		} else {
+			// names are NUL-terminated and padded to a multiple of eight bytes,
+			// the length in the flags is capped for long names
+			length := int(flags & indexFlagNameMask)
+			if length == indexFlagNameMask {
+				length = bytes.IndexByte(data[pos:], 0)
+			}
+			if length < 0 || pos+length >= len(data) {
+				return nil, errIndexCorrupt
+			}
+			name = data[pos : pos+length]
+			pos = start + (pos-start+length+8)&^7
+		}
+		e.Path = string(name)
+		entries = append(entries, e)
+	}
+
+	// check for extensions that change the meaning of the entries
+	for pos+8 <= len(data)-hashSize {
+		signature := string(data[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+		if signature == "link" {
+			return nil, errors.New("split index files are not supported")
+		}
+		if size < 0 || pos+8+size > len(data) {
+			return nil, errIndexCorrupt
+		}
+		pos += 8 + size
+	}
+	return entries, nil
+}
+
+// readOffset reads a variable length integer as used by git for offsets
+// and path prefixes. It returns the value and the number of bytes read,
+// which is 0 for invalid input.
+func readOffset(data []byte) (int, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	c := data[0]
+	value := int(c & 0x7f)
+	n := 1
+	for c&0x80 != 0 {
+		if n >= len(data) || n > 8 {
+			return 0, 0
+		}
+		c = data[n]
+		n++
+		value = ((value + 1) << 7) | int(c&0x7f)
+	}
+	return value, n
+}
@@ -0,0 +1,284 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Hash is the hex-encoded name of an object.
+type Hash string
+
+// ObjectType is the type of an object.
+type ObjectType int
+
+const (
+	ObjectCommit ObjectType = 1
+	ObjectTree   ObjectType = 2
+	ObjectBlob   ObjectType = 3
+	ObjectTag    ObjectType = 4
+)
+
+var objectTypeNames = map[string]ObjectType{
+	"commit": ObjectCommit,
+	"tree":   ObjectTree,
+	"blob":   ObjectBlob,
+	"tag":    ObjectTag,
+}
+
+func (t ObjectType) String() string {
+	for name, typ := range objectTypeNames {
+		if typ == t {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// ErrObjectNotFound is returned if an object is neither stored as loose
+// object nor in a packfile.
+var ErrObjectNotFound = errors.New("object not found")
+
+// objectDir is a directory holding loose objects and packfiles,
+// i.e. the objects directory of a repository or of an alternate.
+type objectDir struct {
+	path  string
+	packs []*pack
+}
+
+// objectStore returns the object directories of the repository, loading
+// the packfile indexes on first use.
+func (r *Repository) objectStore() ([]*objectDir, error) {
+	r.objectsOnce.Do(func() {
+		r.objectCache = newObjectCache(objectCacheSize)
+		r.objectsErr = r.loadObjectDir(filepath.Join(r.CommonDir, "objects"), 0)
+	})
+	return r.objectDirs, r.objectsErr
+}
+
+// loadObjectDir adds an object directory and its alternates.
+func (r *Repository) loadObjectDir(path string, depth int) error {
+	for _, dir := range r.objectDirs {
+		if dir.path == path {
+			return nil
+		}
+	}
+	dir := &objectDir{path: path}
+	idxFiles, err := filepath.Glob(filepath.Join(path, "pack", "*.idx"))
+	if err != nil {
+		return err
+	}
+	for _, idxFile := range idxFiles {
+		p, err := openPack(idxFile, r.HashSize)
+		if err != nil {
+			return fmt.Errorf("cannot open packfile '%s': %s", idxFile, err)
+		}
+		dir.packs = append(dir.packs, p)
+	}
+	r.objectDirs = append(r.objectDirs, dir)
+
+	// alternates list further object directories, one per line
+	if depth >= 5 {
+		return nil
+	}
+	content, err := ioutil.ReadFile(filepath.Join(path, "info", "alternates"))
+	if err != nil {
+		return nil
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(path, line)
+		}
+		if err := r.loadObjectDir(line, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadObject returns the type and content of an object.
+// It is safe to call ReadObject concurrently.
+func (r *Repository) ReadObject(hash Hash) (ObjectType, []byte, error) {
+	raw, err := hex.DecodeString(string(hash))
+	if err != nil || len(raw) != r.HashSize {
+		return 0, nil, fmt.Errorf("invalid object name '%s'", hash)
+	}
+	hash = Hash(hex.EncodeToString(raw))
+	dirs, err := r.objectStore()
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, dir := range dirs {
+		for _, p := range dir.packs {
+			if offset, ok := p.find(raw); ok {
+				return r.readPacked(p, offset, 0)
+			}
+		}
+	}
+	for _, dir := range dirs {
+		typ, data, err := readLooseObject(filepath.Join(dir.path, string(hash[:2]), string(hash[2:])))
+		if os.IsNotExist(err) {
+			continue
+		}
+		return typ, data, err
+	}
+	return 0, nil, ErrObjectNotFound
+}
+
+// readObjectOfType reads an object and checks its type.
+func (r *Repository) readObjectOfType(hash Hash, typ ObjectType) ([]byte, error) {
+	t, data, err := r.ReadObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if t != typ {
+		return nil, fmt.Errorf("object %s is a %s, not a %s", hash, t, typ)
+	}
+	return data, nil
+}
+
+// readLooseObject reads a zlib-compressed object file.
+func readLooseObject(path string) (ObjectType, []byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+	zr, err := zlib.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer zr.Close()
+	content, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// the content is preceded by the header "TYPE SIZE\0"
+	end := bytes.IndexByte(content, 0)
+	if end < 0 {
+		return 0, nil, errors.New("invalid object header")
+	}
+	fields := strings.Fields(string(content[:end]))
+	if len(fields) != 2 {
+		return 0, nil, errors.New("invalid object header")
+	}
+	typ, ok := objectTypeNames[fields[0]]
+	size, err := strconv.Atoi(fields[1])
+	if !ok || err != nil || size != len(content)-end-1 {
+		return 0, nil, errors.New("invalid object header")
+	}
+	return typ, content[end+1:], nil
+}
+
+// findPrefix returns the names of all objects starting with prefix.
+func (r *Repository) findPrefix(prefix string) ([]Hash, error) {
+	dirs, err := r.objectStore()
+	if err != nil {
+		return nil, err
+	}
+	found := map[Hash]bool{}
+	for _, dir := range dirs {
+		for _, p := range dir.packs {
+			for _, hash := range p.findPrefix(prefix) {
+				found[hash] = true
+			}
+		}
+		names, _ := ioutil.ReadDir(filepath.Join(dir.path, prefix[:2]))
+		for _, fi := range names {
+			if name := prefix[:2] + fi.Name(); strings.HasPrefix(name, prefix) && len(name) == 2*r.HashSize {
+				found[Hash(name)] = true
+			}
+		}
+	}
+	hashes := make([]Hash, 0, len(found))
+	for hash := range found {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	return hashes, nil
+}
+
+// objectCacheSize is the total size of the objects kept in memory, mostly
+// used as bases of deltas.
+const objectCacheSize = 32 * 1024 * 1024
+
+type cacheKey struct {
+	pack   *pack
+	offset int64
+}
+
+type cachedObject struct {
+	typ  ObjectType
+	data []byte
+}
+
+// objectCache holds recently read objects of packfiles, evicting the
+// oldest objects once the size limit is reached.
+type objectCache struct {
+	sync.Mutex
+	objects map[cacheKey]cachedObject
+	order   []cacheKey
+	size    int
+	limit   int
+}
+
+func newObjectCache(limit int) *objectCache {
+	return &objectCache{objects: map[cacheKey]cachedObject{}, limit: limit}
+}
+
+func (c *objectCache) get(key cacheKey) (cachedObject, bool) {
+	c.Lock()
+	defer c.Unlock()
+	obj, ok := c.objects[key]
+	return obj, ok
+}
+
+func (c *objectCache) add(key cacheKey, obj cachedObject) {
+	if len(obj.data) > c.limit/4 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.objects[key]; ok {
+		return
+	}
+	for c.size+len(obj.data) > c.limit && len(c.order) > 0 {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		c.size -= len(c.objects[oldest].data)
+		delete(c.objects, oldest)
+	}
+	c.objects[key] = obj
+	c.order = append(c.order, key)
+	c.size += len(obj.data)
+}
@@ -0,0 +1,379 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// types of packed objects in addition to the ObjectTypes
+const (
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+// maxDeltaDepth limits the length of delta chains to detect cycles.
+const maxDeltaDepth = 1000
+
+// maxPreallocation limits the memory allocated for objects based on the
+// sizes given in packfiles, larger objects grow while they are read.
+const maxPreallocation = 16 * 1024 * 1024
+
+var errPackCorrupt = errors.New("packfile corrupt")
+
+// pack is a packfile with its index.
+type pack struct {
+	file     *os.File
+	size     int64
+	hashSize int
+	count    int
+	fanout   []byte
+	// the index holds the sorted object names, for version 1 interleaved
+	// with the offsets of the objects
+	names      []byte
+	nameStride int
+	offsets    []byte
+	// offsets too large for 31 bits (version 2)
+	largeOffsets []byte
+	version      int
+}
+
+// openPack reads the index of a packfile and opens the packfile.
+func openPack(idxFile string, hashSize int) (*pack, error) {
+	idx, err := ioutil.ReadFile(idxFile)
+	if err != nil {
+		return nil, err
+	}
+	p := &pack{hashSize: hashSize}
+	if len(idx) >= 8 && string(idx[:4]) == "\377tOc" {
+		p.version = int(binary.BigEndian.Uint32(idx[4:8]))
+		if p.version != 2 {
+			return nil, fmt.Errorf("unsupported pack index version %d", p.version)
+		}
+		idx = idx[8:]
+	} else {
+		p.version = 1
+	}
+	if len(idx) < 256*4 {
+		return nil, errPackCorrupt
+	}
+	p.fanout = idx[:256*4]
+	p.count = int(binary.BigEndian.Uint32(p.fanout[255*4:]))
+	idx = idx[256*4:]
+	if p.version == 1 {
+		// entries of a 4 byte offset and the object name
+		p.nameStride = 4 + hashSize
+		if len(idx) < p.count*p.nameStride {
+			return nil, errPackCorrupt
+		}
+		p.names = idx[4:]
+		p.offsets = idx
+	} else {
+		// object names, CRC32 checksums, offsets and large offsets
+		p.nameStride = hashSize
+		if len(idx) < p.count*(hashSize+8) {
+			return nil, errPackCorrupt
+		}
+		p.names = idx[:p.count*hashSize]
+		p.offsets = idx[p.count*(hashSize+4) : p.count*(hashSize+8)]
+		p.largeOffsets = idx[p.count*(hashSize+8):]
+	}
+
+	p.file, err = os.Open(strings.TrimSuffix(idxFile, ".idx") + ".pack")
+	if err != nil {
+		return nil, err
+	}
+	fi, err := p.file.Stat()
+	if err != nil {
+		p.file.Close()
+		return nil, err
+	}
+	p.size = fi.Size()
+	return p, nil
+}
+
+// name returns the name of the i-th object of the index.
+func (p *pack) name(i int) []byte {
+	return p.names[i*p.nameStride : i*p.nameStride+p.hashSize]
+}
+
+// offset returns the offset of the i-th object of the index in the packfile.
+func (p *pack) offset(i int) (int64, bool) {
+	if p.version == 1 {
+		return int64(binary.BigEndian.Uint32(p.offsets[i*p.nameStride:])), true
+	}
+	offset := binary.BigEndian.Uint32(p.offsets[i*4:])
+	if offset&0x80000000 == 0 {
+		return int64(offset), true
+	}
+	pos := int(offset&0x7fffffff) * 8
+	if pos+8 > len(p.largeOffsets) {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(p.largeOffsets[pos:])), true
+}
+
+// bucket returns the range of index entries starting with the byte b.
+func (p *pack) bucket(b byte) (int, int) {
+	start := 0
+	if b > 0 {
+		start = int(binary.BigEndian.Uint32(p.fanout[(int(b)-1)*4:]))
+	}
+	end := int(binary.BigEndian.Uint32(p.fanout[int(b)*4:]))
+	if start > end || end > p.count {
+		return 0, 0
+	}
+	return start, end
+}
+
+// find returns the offset of an object in the packfile.
+func (p *pack) find(hash []byte) (int64, bool) {
+	start, end := p.bucket(hash[0])
+	i := start + sort.Search(end-start, func(i int) bool {
+		return bytes.Compare(p.name(start+i), hash) >= 0
+	})
+	if i < end && bytes.Equal(p.name(i), hash) {
+		return p.offset(i)
+	}
+	return 0, false
+}
+
+// findPrefix returns the names of the objects starting with the hex-encoded
+// prefix of at least two characters.
+func (p *pack) findPrefix(prefix string) []Hash {
+	first, err := hex.DecodeString(prefix[:2])
+	if err != nil {
+		return nil
+	}
+	var hashes []Hash
+	start, end := p.bucket(first[0])
+	for i := start; i < end; i++ {
+		if name := hex.EncodeToString(p.name(i)); strings.HasPrefix(name, prefix) {
+			hashes = append(hashes, Hash(name))
+		}
+	}
+	return hashes
+}
+
+// readPacked reads the object at offset of a packfile, resolving deltas.
+func (r *Repository) readPacked(p *pack, offset int64, depth int) (ObjectType, []byte, error) {
+	if depth > maxDeltaDepth {
+		return 0, nil, errors.New("delta chain too long")
+	}
+	key := cacheKey{p, offset}
+	if obj, ok := r.objectCache.get(key); ok {
+		return obj.typ, obj.data, nil
+	}
+
+	// the header holds the type and the size in a variable length encoding,
+	// followed by the base object of deltas
+	header := make([]byte, 16+r.HashSize)
+	n, err := p.file.ReadAt(header, offset)
+	if n == 0 {
+		if err == nil || err == io.EOF {
+			err = errPackCorrupt
+		}
+		return 0, nil, err
+	}
+	header = header[:n]
+	c := header[0]
+	typ := int(c>>4) & 7
+	size := int64(c & 0x0f)
+	pos := 1
+	for shift := uint(4); c&0x80 != 0; shift += 7 {
+		if pos >= len(header) || shift > 56 {
+			return 0, nil, errPackCorrupt
+		}
+		c = header[pos]
+		pos++
+		size |= int64(c&0x7f) << shift
+	}
+
+	var baseOffset int64
+	var baseHash []byte
+	switch typ {
+	case packOfsDelta:
+		distance, n := readOffset(header[pos:])
+		if n == 0 || int64(distance) > offset || distance == 0 {
+			return 0, nil, errPackCorrupt
+		}
+		baseOffset = offset - int64(distance)
+		pos += n
+	case packRefDelta:
+		if pos+r.HashSize > len(header) {
+			return 0, nil, errPackCorrupt
+		}
+		baseHash = header[pos : pos+r.HashSize]
+		pos += r.HashSize
+	case int(ObjectCommit), int(ObjectTree), int(ObjectBlob), int(ObjectTag):
+	default:
+		return 0, nil, fmt.Errorf("invalid object type %d in packfile", typ)
+	}
+
+	if size > (p.size-offset)*1032 {
+		// zlib does not compress better than about 1:1032
+		return 0, nil, errPackCorrupt
+	}
+	data, err := p.inflate(offset+int64(pos), size)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	objType := ObjectType(typ)
+	if typ == packOfsDelta || typ == packRefDelta {
+		var base []byte
+		if baseHash != nil {
+			objType, base, err = r.ReadObject(Hash(hex.EncodeToString(baseHash)))
+		} else {
+			objType, base, err = r.readPacked(p, baseOffset, depth+1)
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		if data, err = applyDelta(base, data); err != nil {
+			return 0, nil, err
+		}
+	}
+	r.objectCache.add(key, cachedObject{objType, data})
+	return objType, data, nil
+}
+
+// inflate reads size bytes of zlib-compressed data at offset.
+func (p *pack) inflate(offset int64, size int64) ([]byte, error) {
+	zr, err := zlib.NewReader(io.NewSectionReader(p.file, offset, p.size-offset))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	// the size is not trusted, the buffer only grows with the inflated data
+	capacity := size
+	if capacity > maxPreallocation {
+		capacity = maxPreallocation
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, capacity))
+	if _, err := io.CopyN(buf, zr, size); err != nil {
+		if err == io.EOF {
+			err = errPackCorrupt
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// applyDelta builds an object from its base and a delta, which consists of
+// the sizes of the base and the result and instructions to copy data from
+// the base or to insert new data.
+func applyDelta(base []byte, delta []byte) ([]byte, error) {
+	errDelta := errors.New("invalid delta")
+	baseSize, n := deltaSize(delta)
+	if n == 0 || baseSize != len(base) {
+		return nil, errDelta
+	}
+	delta = delta[n:]
+	resultSize, n := deltaSize(delta)
+	if n == 0 {
+		return nil, errDelta
+	}
+	delta = delta[n:]
+	// each instruction takes at least one byte of the delta and copies at
+	// most 64 KB of the base or inserts the bytes following it
+	maxCopy := len(base)
+	if maxCopy > 0x10000 {
+		maxCopy = 0x10000
+	}
+	if maxCopy < 1 {
+		maxCopy = 1
+	}
+	if resultSize/maxCopy > len(delta) {
+		return nil, errDelta
+	}
+	capacity := resultSize
+	if capacity > maxPreallocation {
+		capacity = maxPreallocation
+	}
+	result := make([]byte, 0, capacity)
+	for len(delta) > 0 {
+		c := delta[0]
+		delta = delta[1:]
+		switch {
+		case c&0x80 != 0:
+			// copy from the base, the bits of c select the bytes of
+			// the offset and size that follow
+			var offset, size int
+			for i := uint(0); i < 7; i++ {
+				if c&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errDelta
+				}
+				if i < 4 {
+					offset |= int(delta[0]) << (8 * i)
+				} else {
+					size |= int(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > len(base) || len(result)+size > resultSize {
+				return nil, errDelta
+			}
+			result = append(result, base[offset:offset+size]...)
+		case c != 0:
+			// insert the next c bytes
+			size := int(c)
+			if size > len(delta) || len(result)+size > resultSize {
+				return nil, errDelta
+			}
+			result = append(result, delta[:size]...)
+			delta = delta[size:]
+		default:
+			return nil, errDelta
+		}
+	}
+	if len(result) != resultSize {
+		return nil, errDelta
+	}
+	return result, nil
+}
+
+// deltaSize reads a size of a delta in little-endian base 128 encoding.
+// It returns the size and the number of bytes read, which is 0 for invalid input.
+func deltaSize(data []byte) (int, int) {
+	size := 0
+	for i, c := range data {
+		if i > 8 {
+			break
+		}
+		size |= int(c&0x7f) << (7 * uint(i))
+		if c&0x80 == 0 {
+			return size, i + 1
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,90 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package git
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeltaSize(t *testing.T) {
+	tests := []struct {
+		data []byte
+		size int
+		n    int
+	}{
+		{[]byte{0x00}, 0, 1},
+		{[]byte{0x05, 0xff}, 5, 1},
+		{[]byte{0x7f}, 127, 1},
+		{[]byte{0x80, 0x01}, 128, 2},
+		{[]byte{0xe5, 0x8e, 0x26}, 624485, 3},
+		{[]byte{0xff, 0xff, 0x03}, 0xffff, 3},
+		{[]byte{}, 0, 0},
+		{[]byte{0x80}, 0, 0},
+		{[]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, 1 << 56, 9},
+		{[]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, 0, 0},
+	}
+	for _, test := range tests {
+		size, n := deltaSize(test.data)
+		if size != test.size || n != test.n {
+			t.Errorf("deltaSize(%x) = %d, %d, want %d, %d", test.data, size, n, test.size, test.n)
+		}
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("the quick brown fox")
+	// 0x10010 bytes, encoded as 0x90 0x80 0x04
+	large := bytes.Repeat([]byte("0123456789abcdef"), 0x1001)
+	tests := []struct {
+		name  string
+		base  []byte
+		delta []byte
+		want  []byte
+	}{
+		{"copy", base, []byte{19, 9, 0x91, 4, 6, 0x90, 3}, []byte("quick the")},
+		{"copy with implicit offset", base, []byte{19, 3, 0x90, 3}, []byte("the")},
+		{"insert", base, []byte{19, 3, 3, 'a', 'b', 'c'}, []byte("abc")},
+		{"copy and insert", base, []byte{19, 8, 0x90, 4, 4, 'c', 'a', 't', '!'}, []byte("the cat!")},
+		{"copy of 64 KB", large, []byte{0x90, 0x80, 0x04, 0x80, 0x80, 0x04, 0x80}, large[:0x10000]},
+		{"two-byte offset", large, []byte{0x90, 0x80, 0x04, 2, 0x93, 0x00, 0x01, 2}, []byte("01")},
+		{"empty result", base, []byte{19, 0}, []byte{}},
+		{"base size mismatch", base, []byte{18, 3, 0x90, 3}, nil},
+		{"copy beyond base", base, []byte{19, 4, 0x91, 17, 4}, nil},
+		{"copy beyond result", base, []byte{19, 2, 0x90, 3}, nil},
+		{"insert beyond result", base, []byte{19, 2, 3, 'a', 'b', 'c'}, nil},
+		{"truncated copy", base, []byte{19, 3, 0x91, 4}, nil},
+		{"truncated insert", base, []byte{19, 3, 3, 'a', 'b'}, nil},
+		{"reserved instruction", base, []byte{19, 1, 0, 1, 'a'}, nil},
+		{"short result", base, []byte{19, 4, 3, 'a', 'b', 'c'}, nil},
+		{"truncated header", base, []byte{19, 0x80}, nil},
+		{"implausible result size", base, []byte{19, 0xff, 0xff, 0xff, 0xff, 0x0f, 0x90, 3}, nil},
+	}
+	for _, test := range tests {
+		got, err := applyDelta(test.base, test.delta)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%s: applyDelta succeeded, want error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: applyDelta failed: %s", test.name, err)
+		} else if !bytes.Equal(got, test.want) {
+			t.Errorf("%s: applyDelta = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+/*
+Package git reads git repositories without requiring the git binary.
+
+It locates work trees and their git directories, including linked
+work trees and submodules with a .git file, reads git config values,
+the list of tracked files from the index and objects stored as loose
+objects or in packfiles. Revisions are resolved from object names and
+refs with a subset of the gitrevisions syntax. Files of the work tree
+can be compared with revisions down to the changed lines.
+*/
+package git
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DotGit is the name of the git directory (or file) at the root of a work tree.
+const DotGit = ".git"
+
+// ErrNotRepository is returned if no git work tree is found.
+var ErrNotRepository = errors.New("not a git repository")
+
+// Repository is a git work tree.
+type Repository struct {
+	// Root is the root directory of the work tree.
+	Root string
+	// GitDir is the git directory of the work tree.
+	GitDir string
+	// CommonDir is the directory holding the files shared by all work trees,
+	// like objects and config. It equals GitDir unless Root is a linked work tree.
+	CommonDir string
+	// HashSize is the size of object names in bytes (20 for SHA-1, 32 for SHA-256).
+	HashSize int
+
+	objectsOnce sync.Once
+	objectDirs  []*objectDir
+	objectsErr  error
+	objectCache *objectCache
+}
+
+// Open returns the repository with its work tree at root.
+func Open(root string) (*Repository, error) {
+	gitDir := GitDir(root)
+	if gitDir == "" {
+		return nil, ErrNotRepository
+	}
+	repo := &Repository{Root: root, GitDir: gitDir, CommonDir: CommonDir(gitDir), HashSize: 20}
+	if format, ok := ConfigValue(filepath.Join(repo.CommonDir, "config"), "extensions", "objectformat"); ok && strings.EqualFold(format, "sha256") {
+		repo.HashSize = 32
+	}
+	return repo, nil
+}
+
+// Find returns the repository containing path by searching path and
+// its parent directories for the root of a work tree.
+func Find(path string) (*Repository, error) {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if IsRepositoryRoot(dir) {
+			return Open(dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, ErrNotRepository
+		}
+		dir = parent
+	}
+}
+
+// IsRepositoryRoot checks whether path is the root of a git work tree.
+// In linked work trees and submodules, .git is a file instead of a directory.
+func IsRepositoryRoot(path string) bool {
+	_, err := os.Stat(filepath.Join(path, DotGit))
+	return err == nil
+}
+
+// GitDir returns the git directory of the work tree at root, following the
+// "gitdir:" reference of a .git file. It returns "" if root is not a work tree.
+func GitDir(root string) string {
+	dotGit := filepath.Join(root, DotGit)
+	fi, err := os.Stat(dotGit)
+	if err != nil {
+		return ""
+	}
+	if fi.IsDir() {
+		return dotGit
+	}
+	content, err := ioutil.ReadFile(dotGit)
+	if err != nil {
+		return ""
+	}
+	line := strings.TrimSpace(string(content))
+	if !strings.HasPrefix(line, "gitdir:") {
+		return ""
+	}
+	dir := strings.TrimSpace(line[len("gitdir:"):])
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	return dir
+}
+
+// CommonDir returns the directory holding the files shared by all work trees
+// of a repository, like info/exclude.
+func CommonDir(gitDir string) string {
+	content, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		return gitDir
+	}
+	dir := strings.TrimSpace(string(content))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(gitDir, dir)
+	}
+	return dir
+}
+
+// ConfigValue returns the last value of a key in a section of a git config
+// file. Section and key are compared case-insensitively, subsections and
+// includes are not supported.
+func ConfigValue(path string, section string, key string) (value string, found bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	inSection := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				continue
+			}
+			inSection = strings.EqualFold(strings.TrimSpace(line[1:end]), section)
+			line = strings.TrimSpace(line[end+1:])
+			if line == "" {
+				continue
+			}
+		}
+		if !inSection {
+			continue
+		}
+		name, val := line, ""
+		if pos := strings.IndexByte(line, '='); pos >= 0 {
+			name, val = strings.TrimSpace(line[:pos]), strings.TrimSpace(line[pos+1:])
+		}
+		if strings.EqualFold(name, key) {
+			value, found = unquoteConfigValue(val), true
+		}
+	}
+	return value, found
+}
+
+// unquoteConfigValue removes quotes, escapes and trailing comments from a
+// git config value.
+func unquoteConfigValue(value string) string {
+	var res strings.Builder
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && i+1 < len(value):
+			i++
+			switch value[i] {
+			case 't':
+				res.WriteByte('\t')
+			case 'n':
+				res.WriteByte('\n')
+			default:
+				res.WriteByte(value[i])
+			}
+		case (c == '#' || c == ';') && !quoted:
+			return strings.TrimSpace(res.String())
+		default:
+			res.WriteByte(c)
+		}
+	}
+	return strings.TrimSpace(res.String())
+}
@@ -0,0 +1,374 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var errInvalidRevision = errors.New("invalid revision")
+
+// ErrUnknownRevision is returned by Resolve if a name does not refer to an
+// object, e.g. HEAD in a repository without commits.
+var ErrUnknownRevision = errors.New("unknown revision")
+
+// TreeEntry is an entry of a tree object.
+type TreeEntry struct {
+	Name string
+	// Mode is the file mode, see ModeRegular, ModeSymlink, ModeGitlink and ModeDir.
+	Mode uint32
+	Hash Hash
+}
+
+// Resolve returns the object named by rev. Supported are full and abbreviated
+// object names, HEAD, refs as in "git rev-parse" (e.g. "v1.0", "main" or
+// "origin/main") followed by any number of the suffixes ~N, ^N and ^{}.
+func (r *Repository) Resolve(rev string) (Hash, error) {
+	base := rev
+	suffix := ""
+	if pos := strings.IndexAny(rev, "~^"); pos >= 0 {
+		base, suffix = rev[:pos], rev[pos:]
+	}
+	hash, err := r.resolveName(base)
+	if err != nil {
+		return "", err
+	}
+
+	for suffix != "" {
+		op := suffix[0]
+		suffix = suffix[1:]
+		if op == '^' && strings.HasPrefix(suffix, "{}") {
+			suffix = suffix[2:]
+			if hash, err = r.peel(hash, 0); err != nil {
+				return "", err
+			}
+			continue
+		}
+		end := 0
+		for end < len(suffix) && suffix[end] >= '0' && suffix[end] <= '9' {
+			end++
+		}
+		n := 1
+		if end > 0 {
+			if n, err = strconv.Atoi(suffix[:end]); err != nil {
+				return "", errInvalidRevision
+			}
+		}
+		suffix = suffix[end:]
+		switch op {
+		case '~':
+			// the n-th generation ancestor following first parents
+			for i := 0; i < n && err == nil; i++ {
+				hash, err = r.parent(hash, 1)
+			}
+		case '^':
+			hash, err = r.parent(hash, n)
+		default:
+			err = errInvalidRevision
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hash, nil
+}
+
+// resolveName resolves an object name or ref.
+func (r *Repository) resolveName(name string) (Hash, error) {
+	if name == "" || name == "@" {
+		name = "HEAD"
+	}
+	if len(name) == 2*r.HashSize && isHex(name) {
+		return Hash(strings.ToLower(name)), nil
+	}
+	for _, candidate := range []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name,
+		"refs/remotes/" + name, "refs/remotes/" + name + "/HEAD"} {
+		if hash, ok := r.ref(candidate, 0); ok {
+			return hash, nil
+		}
+	}
+	if len(name) >= 4 && len(name) < 2*r.HashSize && isHex(name) {
+		hashes, err := r.findPrefix(strings.ToLower(name))
+		if err != nil {
+			return "", err
+		}
+		switch len(hashes) {
+		case 0:
+		case 1:
+			return hashes[0], nil
+		default:
+			return "", errors.New("short object name is ambiguous")
+		}
+	}
+	return "", ErrUnknownRevision
+}
+
+// ref reads a ref, following symbolic refs.
+func (r *Repository) ref(name string, depth int) (Hash, bool) {
+	if depth > 5 || strings.Contains(name, "..") {
+		return "", false
+	}
+	// HEAD and the refs below refs/worktree and refs/bisect belong to the
+	// work tree, all other refs are shared
+	dir := r.CommonDir
+	if !strings.HasPrefix(name, "refs/") || strings.HasPrefix(name, "refs/worktree/") || strings.HasPrefix(name, "refs/bisect/") {
+		dir = r.GitDir
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err == nil {
+		value := strings.TrimSpace(string(content))
+		if strings.HasPrefix(value, "ref:") {
+			return r.ref(strings.TrimSpace(value[len("ref:"):]), depth+1)
+		}
+		if len(value) == 2*r.HashSize && isHex(value) {
+			return Hash(value), true
+		}
+		return "", false
+	}
+	hash, ok := r.packedRefs()[name]
+	return hash, ok
+}
+
+// packedRefs returns the refs of the packed-refs file.
+func (r *Repository) packedRefs() map[string]Hash {
+	refs := map[string]Hash{}
+	file, err := os.Open(filepath.Join(r.CommonDir, "packed-refs"))
+	if err != nil {
+		return refs
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// lines starting with '^' hold the peeled object of the preceding tag
+		line := scanner.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 2 && len(fields[0]) == 2*r.HashSize {
+			refs[fields[1]] = Hash(fields[0])
+		}
+	}
+	return refs
+}
+
+// Refs returns the objects referenced by HEAD and all refs.
+func (r *Repository) Refs() ([]Hash, error) {
+	refs := r.packedRefs()
+	refsDir := filepath.Join(r.CommonDir, "refs")
+	err := filepath.Walk(refsDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(r.CommonDir, path)
+		if err != nil {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		if hash, ok := r.ref(name, 0); ok {
+			refs[name] = hash
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []Hash
+	if hash, ok := r.ref("HEAD", 0); ok {
+		hashes = append(hashes, hash)
+	}
+	for _, hash := range refs {
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// Commits returns the commits reachable from the given objects, which are
+// peeled to commits first. Objects not pointing to commits are skipped.
+func (r *Repository) Commits(start []Hash) ([]Hash, error) {
+	seen := map[Hash]bool{}
+	var queue []Hash
+	for _, hash := range start {
+		if commit, err := r.peel(hash, ObjectCommit); err == nil && !seen[commit] {
+			seen[commit] = true
+			queue = append(queue, commit)
+		}
+	}
+	for i := 0; i < len(queue); i++ {
+		data, err := r.readObjectOfType(queue[i], ObjectCommit)
+		if err != nil {
+			return nil, err
+		}
+		_, parents := parseCommit(data)
+		for _, parent := range parents {
+			if !seen[parent] {
+				seen[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return queue, nil
+}
+
+// Tree returns the tree of a commit or of the commit or tree a tag points to.
+func (r *Repository) Tree(hash Hash) (Hash, error) {
+	hash, err := r.peel(hash, ObjectTree)
+	if err != nil {
+		return "", err
+	}
+	typ, data, err := r.ReadObject(hash)
+	if err != nil {
+		return "", err
+	}
+	if typ == ObjectCommit {
+		tree, _ := parseCommit(data)
+		if tree == "" {
+			return "", fmt.Errorf("commit %s has no tree", hash)
+		}
+		return tree, nil
+	}
+	return hash, nil
+}
+
+// ReadTree returns the entries of a tree object.
+func (r *Repository) ReadTree(hash Hash) ([]TreeEntry, error) {
+	data, err := r.readObjectOfType(hash, ObjectTree)
+	if err != nil {
+		return nil, err
+	}
+	// entries consist of the octal mode, a space, the name terminated by
+	// NUL and the binary object name
+	var entries []TreeEntry
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		if space < 0 {
+			return nil, fmt.Errorf("tree %s corrupt", hash)
+		}
+		mode, err := strconv.ParseUint(string(data[:space]), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("tree %s corrupt", hash)
+		}
+		data = data[space+1:]
+		end := bytes.IndexByte(data, 0)
+		if end < 0 || end+1+r.HashSize > len(data) {
+			return nil, fmt.Errorf("tree %s corrupt", hash)
+		}
+		entries = append(entries, TreeEntry{
+			Name: string(data[:end]),
+			Mode: uint32(mode),
+			Hash: Hash(hex.EncodeToString(data[end+1 : end+1+r.HashSize])),
+		})
+		data = data[end+1+r.HashSize:]
+	}
+	return entries, nil
+}
+
+// peel follows tags until an object of the given type or a commit is found.
+// With typ 0, all tags are followed.
+func (r *Repository) peel(hash Hash, typ ObjectType) (Hash, error) {
+	for i := 0; i < 10; i++ {
+		t, data, err := r.ReadObject(hash)
+		if err != nil {
+			return "", err
+		}
+		if t != ObjectTag || typ == ObjectTag {
+			if typ != 0 && t != typ && t != ObjectCommit {
+				return "", fmt.Errorf("object %s is a %s", hash, t)
+			}
+			return hash, nil
+		}
+		object := headerValue(data, "object")
+		if object == "" {
+			return "", fmt.Errorf("tag %s corrupt", hash)
+		}
+		hash = object
+	}
+	return "", errors.New("too many nested tags")
+}
+
+// parent returns the n-th parent of a commit, the commit itself for n = 0.
+func (r *Repository) parent(hash Hash, n int) (Hash, error) {
+	hash, err := r.peel(hash, ObjectCommit)
+	if err != nil {
+		return "", err
+	}
+	data, err := r.readObjectOfType(hash, ObjectCommit)
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return hash, nil
+	}
+	_, parents := parseCommit(data)
+	if n > len(parents) {
+		return "", fmt.Errorf("commit %s has no parent %d", hash, n)
+	}
+	return parents[n-1], nil
+}
+
+// parseCommit returns the tree and the parents of a commit.
+func parseCommit(data []byte) (tree Hash, parents []Hash) {
+	for _, line := range headerLines(data) {
+		switch {
+		case strings.HasPrefix(line, "tree "):
+			tree = Hash(line[len("tree "):])
+		case strings.HasPrefix(line, "parent "):
+			parents = append(parents, Hash(line[len("parent "):]))
+		}
+	}
+	return tree, parents
+}
+
+// headerValue returns the value of the first header line of a commit or tag
+// starting with key.
+func headerValue(data []byte, key string) Hash {
+	for _, line := range headerLines(data) {
+		if strings.HasPrefix(line, key+" ") {
+			return Hash(line[len(key)+1:])
+		}
+	}
+	return ""
+}
+
+// headerLines returns the header lines of a commit or tag, which end
+// with an empty line.
+func headerLines(data []byte) []string {
+	if end := bytes.Index(data, []byte("\n\n")); end >= 0 {
+		data = data[:end]
+	}
+	return strings.Split(string(data), "\n")
+}
+
+// isHex checks whether s consists of hexadecimal digits.
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
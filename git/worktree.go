@@ -0,0 +1,92 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package git
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// HashObject returns the name of an object of the given type and content.
+func (r *Repository) HashObject(typ ObjectType, data []byte) Hash {
+	var h hash.Hash
+	if r.HashSize == sha256.Size {
+		h = sha256.New()
+	} else {
+		h = sha1.New()
+	}
+	fmt.Fprintf(h, "%s %d\x00", typ, len(data))
+	h.Write(data)
+	return Hash(hex.EncodeToString(h.Sum(nil)))
+}
+
+// WorktreeHash returns the name of the blob of the file of an index entry in
+// the work tree. The file is only read if its size or modification time differ
+// from the index entry. Content filters like end-of-line conversion are not applied.
+func (r *Repository) WorktreeHash(e IndexEntry) (Hash, error) {
+	path := filepath.Join(r.Root, filepath.FromSlash(e.Path))
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	if !e.racy && !e.IntentToAdd && uint32(fi.Size()) == e.Size && fi.ModTime().Equal(e.ModTime) {
+		return Hash(hex.EncodeToString(e.Hash)), nil
+	}
+	var data []byte
+	if fi.Mode()&os.ModeSymlink != 0 {
+		// the blob of a symlink holds the link target
+		var target string
+		target, err = os.Readlink(path)
+		data = []byte(filepath.ToSlash(target))
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return r.HashObject(ObjectBlob, data), nil
+}
+
+// Files returns the entries of a tree and its subtrees except for the trees
+// themselves by slash-separated path.
+func (r *Repository) Files(tree Hash) (map[string]TreeEntry, error) {
+	files := map[string]TreeEntry{}
+	return files, r.addFiles(files, tree, "")
+}
+
+// addFiles adds the entries of a tree to files, prefixing their names with prefix.
+func (r *Repository) addFiles(files map[string]TreeEntry, tree Hash, prefix string) error {
+	entries, err := r.ReadTree(tree)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Mode&ModeTypeMask == ModeDir {
+			if err := r.addFiles(files, e.Hash, prefix+e.Name+"/"); err != nil {
+				return err
+			}
+			continue
+		}
+		files[prefix+e.Name] = e
+	}
+	return nil
+}
@@ -23,6 +23,14 @@ documented here: https://git-scm.com/docs/gitignore
 Multiple .gitignore files with multiple matching patterns
 are supported. A cache is used to prevent loading the same
 .gitignore file again when checking different paths.
+
+Within a git repository, the .gitignore files up to the root of the
+repository apply, followed by the repository's info/exclude file and
+the file set by core.excludesFile (default: ~/.config/git/ignore).
+Outside of a repository, the .gitignore files of all parent
+directories apply. The tool-neutral .ignore and .siftignore files use
+the same syntax and take precedence over a .gitignore file in the
+same directory, .siftignore over .ignore.
 */
 package gitignore
 
@@ -34,13 +42,21 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+
+	"github.com/svent/sift/git"
 )
 
 const (
-	GitIgnoreFilename = ".gitignore"
-	GitFoldername     = ".git"
+	GitIgnoreFilename  = ".gitignore"
+	IgnoreFilename     = ".ignore"
+	SiftIgnoreFilename = ".siftignore"
+	GitFoldername      = ".git"
 )
 
+// ignoreFilenames are the names of the files holding ignore patterns,
+// in order of precedence within a directory.
+var ignoreFilenames = []string{SiftIgnoreFilename, IgnoreFilename, GitIgnoreFilename}
+
 // Checker allows to check whether a given file is excluded by the
 // relevant .gitignore files for a given base path and holds
 // a cache of already parsed .gitignore files.
@@ -56,9 +72,11 @@ type gitIgnore struct {
 	patterns []patternMatcher
 }
 
-// GitIgnoreCache holds already parsed .gitignore files.
+// GitIgnoreCache holds already parsed .gitignore files and
+// the ignore files applying to already checked directories.
 type GitIgnoreCache struct {
 	cache map[string]*gitIgnore
+	dirs  map[string][]*gitIgnore
 	mu    sync.RWMutex
 }
 
@@ -66,6 +84,11 @@ type GitIgnoreCache struct {
 type basePattern struct {
 	// the base path of the corresponding .gitignore file
 	basePath string
+	// the file and line the pattern was read from
+	file string
+	line int
+	// the pattern as written in the file
+	text string
 	// only match directories (pattern ends with "/")
 	matchDirOnly bool
 	// include (pattern starts with "!")
@@ -105,6 +128,18 @@ type regexPattern struct {
 type patternMatcher interface {
 	Matches(string, os.FileInfo) bool
 	Negated() bool
+	origin() basePattern
+}
+
+// Match describes the pattern deciding whether a path is excluded.
+type Match struct {
+	// the ignore file and line number of the pattern
+	File string
+	Line int
+	// the pattern as written in the file
+	Pattern string
+	// false if the pattern is negated and includes the path again
+	Ignored bool
 }
 
 // NewChecker returns a new Checker instance.
@@ -123,14 +158,28 @@ func NewCheckerWithCache(cache *GitIgnoreCache) *Checker {
 
 // Check returns whether the specified path is excluded by a .gitignore file.
 func (c *Checker) Check(path string, fi os.FileInfo) bool {
-	res := false
+	p := c.match(path, fi)
+	return p != nil && !p.Negated()
+}
+
+// Match returns the pattern deciding whether the specified path is excluded,
+// nil if no pattern matches the path.
+func (c *Checker) Match(path string, fi os.FileInfo) *Match {
+	p := c.match(path, fi)
+	if p == nil {
+		return nil
+	}
+	origin := p.origin()
+	return &Match{File: origin.file, Line: origin.line, Pattern: origin.text, Ignored: !p.Negated()}
+}
+
+func (c *Checker) match(path string, fi os.FileInfo) patternMatcher {
 	for _, gi := range c.gitIgnores {
-		if ignore, matched := gi.check(path, fi); matched {
-			res = ignore
-			break
+		if p := gi.check(path, fi); p != nil {
+			return p
 		}
 	}
-	return res
+	return nil
 }
 
 // LoadBasePath initializes the Checker instance with a new base path
@@ -146,57 +195,50 @@ func (c *Checker) LoadBasePath(path string) error {
 		return err
 	}
 
-	c.gitIgnores = []*gitIgnore{}
+	c.gitIgnores, err = c.gitIgnoreCache.dirIgnores(curPath)
+	return err
+}
 
-	lastPath := ""
-	for curPath != lastPath {
-		ignoreFile := filepath.Join(curPath, GitIgnoreFilename)
-		if _, err := os.Stat(ignoreFile); err == nil {
-			var gi *gitIgnore
-			gi, err = c.gitIgnoreCache.get(ignoreFile)
-			if err != nil {
-				return err
-			}
-			c.gitIgnores = append(c.gitIgnores, gi)
+// IsIgnoreFile checks whether name is the name of a file holding ignore patterns.
+func IsIgnoreFile(name string) bool {
+	for _, ignoreFilename := range ignoreFilenames {
+		if name == ignoreFilename {
+			return true
 		}
-		lastPath = curPath
-		curPath = filepath.Dir(curPath)
 	}
-
-	return nil
+	return false
 }
 
-// newGitIgnore returns a gitIgnore instance for the given .gitignore file.
-func newGitIgnore(path string) (*gitIgnore, error) {
-	basePath := filepath.Dir(path)
+// newGitIgnore returns a gitIgnore instance for the given ignore file,
+// its patterns are relative to basePath.
+func newGitIgnore(path string, basePath string) (*gitIgnore, error) {
 	var gi *gitIgnore = &gitIgnore{basePath: basePath}
 	err := gi.loadIgnoreFile(path)
 	return gi, err
 }
 
-// check checks whether the given path is excluded by the gitIgnore instance.
-func (gi gitIgnore) check(path string, fi os.FileInfo) (ignore bool, matched bool) {
+// check returns the last pattern of the gitIgnore instance matching
+// the given path, nil if no pattern matches.
+func (gi gitIgnore) check(path string, fi os.FileInfo) patternMatcher {
 	fullpath, _ := filepath.Abs(path)
 	if len(fullpath) <= len(gi.basePath) || !strings.HasPrefix(fullpath, gi.basePath) {
-		return false, false
+		return nil
 	}
 
 	testpath := fullpath[len(gi.basePath)+1:]
 	for i := len(gi.patterns) - 1; i >= 0; i-- {
 		p := gi.patterns[i]
 		if p.Matches(testpath, fi) {
-			ignore = !p.Negated()
-			matched = true
-			return
+			return p
 		}
 	}
-	return false, false
+	return nil
 }
 
 // loadIgnoreFile loads a .gitignore file and processes
 // all found patterns.
 func (c *gitIgnore) loadIgnoreFile(path string) error {
-	basePath := filepath.Dir(path)
+	basePath := c.basePath
 	file, err := os.Open(path)
 	if err != nil {
 		return err
@@ -204,8 +246,8 @@ func (c *gitIgnore) loadIgnoreFile(path string) error {
 	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		c.addPattern(scanner.Text(), basePath)
+	for line := 1; scanner.Scan(); line++ {
+		c.addPattern(scanner.Text(), basePattern{basePath: basePath, file: path, line: line})
 	}
 	if err = scanner.Err(); err != nil {
 		return err
@@ -215,8 +257,9 @@ func (c *gitIgnore) loadIgnoreFile(path string) error {
 }
 
 // addPattern parses the given pattern and adds it to
-// the gitIgnore instance.
-func (c *gitIgnore) addPattern(pattern string, basePath string) {
+// the gitIgnore instance. The origin holds the base path
+// and the location of the pattern.
+func (c *gitIgnore) addPattern(pattern string, origin basePattern) {
 	negated := false
 	matchDirOnly := false
 	leadingSlash := false
@@ -227,6 +270,7 @@ func (c *gitIgnore) addPattern(pattern string, basePath string) {
 	if strings.HasPrefix(pattern, "#") {
 		return
 	}
+	text := pattern
 
 	if strings.HasPrefix(pattern, "!") {
 		negated = true
@@ -246,7 +290,10 @@ func (c *gitIgnore) addPattern(pattern string, basePath string) {
 	var p patternMatcher
 	var base basePattern
 	base = basePattern{
-		basePath:     basePath,
+		basePath:     origin.basePath,
+		file:         origin.file,
+		line:         origin.line,
+		text:         text,
 		content:      pattern,
 		negated:      negated,
 		leadingSlash: leadingSlash,
@@ -272,32 +319,90 @@ func (c *gitIgnore) addPattern(pattern string, basePath string) {
 func NewGitIgnoreCache() *GitIgnoreCache {
 	c := &GitIgnoreCache{}
 	c.cache = make(map[string]*gitIgnore)
+	c.dirs = make(map[string][]*gitIgnore)
 	return c
 }
 
 // get returns the matching GitIgnore instance from the cache or
 // creates a new one and stores it in the cache.
-func (c *GitIgnoreCache) get(path string) (*gitIgnore, error) {
+func (c *GitIgnoreCache) get(path string, basePath string) (*gitIgnore, error) {
+	// exclude files of a repository may be shared with other repositories
+	key := basePath + string(filepath.Separator) + path
 	c.mu.RLock()
-	if gi, ok := c.cache[path]; ok {
+	if gi, ok := c.cache[key]; ok {
 		c.mu.RUnlock()
 		return gi, nil
 	}
 	c.mu.RUnlock()
-	gi, err := newGitIgnore(path)
+	gi, err := newGitIgnore(path, basePath)
 	if err != nil {
 		return nil, err
 	}
 	c.mu.Lock()
-	c.cache[path] = gi
+	c.cache[key] = gi
 	c.mu.Unlock()
 	return gi, nil
 }
 
+// dirIgnores returns the ignore files applying to the files in the directory
+// dir in order of precedence: the ignore files of dir and its parents up to
+// the repository root, followed by the exclude files of the repository.
+// The result is stored in the cache unless an ignore file cannot be loaded.
+func (c *GitIgnoreCache) dirIgnores(dir string) ([]*gitIgnore, error) {
+	c.mu.RLock()
+	if gitIgnores, ok := c.dirs[dir]; ok {
+		c.mu.RUnlock()
+		return gitIgnores, nil
+	}
+	c.mu.RUnlock()
+
+	var firstErr error
+	gitIgnores := []*gitIgnore{}
+	add := func(ignoreFile string, basePath string) {
+		if _, err := os.Stat(ignoreFile); err != nil {
+			return
+		}
+		gi, err := c.get(ignoreFile, basePath)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		gitIgnores = append(gitIgnores, gi)
+	}
+
+	for _, name := range ignoreFilenames {
+		add(filepath.Join(dir, name), dir)
+	}
+	if git.IsRepositoryRoot(dir) {
+		for _, excludeFile := range excludeFiles(dir) {
+			add(excludeFile, dir)
+		}
+	} else if parent := filepath.Dir(dir); parent != dir {
+		parentIgnores, err := c.dirIgnores(parent)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		gitIgnores = append(gitIgnores, parentIgnores...)
+	}
+
+	if firstErr == nil {
+		c.mu.Lock()
+		c.dirs[dir] = gitIgnores
+		c.mu.Unlock()
+	}
+	return gitIgnores, firstErr
+}
+
 func (p basePattern) Negated() bool {
 	return p.negated
 }
 
+func (p basePattern) origin() basePattern {
+	return p
+}
+
 func newSimplePattern(base basePattern) patternMatcher {
 	return simplePattern{base}
 }
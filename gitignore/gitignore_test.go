@@ -0,0 +1,146 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gitignore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles creates the given files below dir. Names ending with "/"
+// are created as directories.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheckerPrecedence(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "sift-gitignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// do not use the exclude files of the user running the tests
+	for _, name := range []string{"HOME", "XDG_CONFIG_HOME"} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Setenv(name, tmp)
+	}
+
+	writeFiles(t, tmp, map[string]string{
+		".gitignore":              "*.txt\n",
+		"git/ignore":              "*.bak\n*.log\n",
+		"repo/.git/info/exclude":  "*.log\n!keep.log\n",
+		"repo/.gitignore":         "*.tmp\n!important.tmp\nbuild/\ndocs/*.md\n!docs/README.md\n",
+		"repo/.ignore":            "!override.tmp\n!sift.tmp\n",
+		"repo/.siftignore":        "sift.tmp\n",
+		"repo/sub/.gitignore":     "!*.tmp\nlocal.txt\n",
+		"repo/build/":             "",
+		"repo/sub/build":          "",
+		"repo/a.tmp":              "",
+		"repo/a.txt":              "",
+		"repo/important.tmp":      "",
+		"repo/override.tmp":       "",
+		"repo/sift.tmp":           "",
+		"repo/docs/a.md":          "",
+		"repo/docs/README.md":     "",
+		"repo/x.log":              "",
+		"repo/keep.log":           "",
+		"repo/x.bak":              "",
+		"repo/sub/a.tmp":          "",
+		"repo/sub/local.txt":      "",
+		"repo/sub/x.bak":          "",
+		"repo/sub/deeper/b.tmp":   "",
+		"repo/sub/deeper/x.log":   "",
+		"repo/sub/deeper/a.txt":   "",
+		"repo/sub/deeper/keep.md": "",
+	})
+	repo := filepath.Join(tmp, "repo")
+
+	tests := []struct {
+		path    string
+		file    string // ignore file of the deciding pattern, "" for no match
+		line    int
+		ignored bool
+	}{
+		{"a.tmp", ".gitignore", 1, true},
+		// later patterns take precedence over earlier ones
+		{"important.tmp", ".gitignore", 2, false},
+		// .ignore takes precedence over .gitignore, .siftignore over .ignore
+		{"override.tmp", ".ignore", 1, false},
+		{"sift.tmp", ".siftignore", 1, true},
+		// the .gitignore files of the parents of the repository do not apply
+		{"a.txt", "", 0, false},
+		{"build", ".gitignore", 3, true},
+		{"sub/build", "", 0, false},
+		{"docs/a.md", ".gitignore", 4, true},
+		{"docs/README.md", ".gitignore", 5, false},
+		// info/exclude takes precedence over core.excludesFile
+		{"x.log", ".git/info/exclude", 1, true},
+		{"keep.log", ".git/info/exclude", 2, false},
+		{"x.bak", "../git/ignore", 1, true},
+		// .gitignore files in subdirectories take precedence over their parents
+		{"sub/a.tmp", "sub/.gitignore", 1, false},
+		{"sub/local.txt", "sub/.gitignore", 2, true},
+		{"sub/x.bak", "../git/ignore", 1, true},
+		{"sub/deeper/b.tmp", "sub/.gitignore", 1, false},
+		{"sub/deeper/x.log", ".git/info/exclude", 1, true},
+		{"sub/deeper/a.txt", "", 0, false},
+		{"sub/deeper/keep.md", "", 0, false},
+	}
+	checker := NewChecker()
+	for _, test := range tests {
+		path := filepath.Join(repo, filepath.FromSlash(test.path))
+		fi, err := os.Lstat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checker.LoadBasePath(filepath.Dir(path)); err != nil {
+			t.Fatalf("LoadBasePath(%s): %s", filepath.Dir(path), err)
+		}
+		m := checker.Match(path, fi)
+		if test.file == "" {
+			if m != nil {
+				t.Errorf("%s: matched by %s:%d (%s), want no match", test.path, m.File, m.Line, m.Pattern)
+			}
+			continue
+		}
+		want := filepath.Join(repo, filepath.FromSlash(test.file))
+		if m == nil {
+			t.Errorf("%s: no match, want %s:%d", test.path, want, test.line)
+		} else if m.File != want || m.Line != test.line || m.Ignored != test.ignored {
+			t.Errorf("%s: matched by %s:%d (ignored: %t), want %s:%d (ignored: %t)",
+				test.path, m.File, m.Line, m.Ignored, want, test.line, test.ignored)
+		}
+		if checker.Check(path, fi) != test.ignored {
+			t.Errorf("%s: Check = %t, want %t", test.path, !test.ignored, test.ignored)
+		}
+	}
+}
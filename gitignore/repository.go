@@ -0,0 +1,68 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gitignore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/svent/sift/git"
+)
+
+// excludeFiles returns the exclude files of the repository at root in
+// order of precedence: info/exclude and the file set by core.excludesFile.
+func excludeFiles(root string) []string {
+	gitDir := git.GitDir(root)
+	if gitDir == "" {
+		return nil
+	}
+	files := []string{filepath.Join(git.CommonDir(gitDir), "info", "exclude")}
+	if file := globalExcludesFile(gitDir); file != "" {
+		files = append(files, file)
+	}
+	return files
+}
+
+// globalExcludesFile returns the file set by core.excludesFile in the
+// repository, global or XDG configuration (in order of precedence) or the
+// default $XDG_CONFIG_HOME/git/ignore.
+func globalExcludesFile(gitDir string) string {
+	xdgConfig := os.Getenv("XDG_CONFIG_HOME")
+	home, _ := os.UserHomeDir()
+	if xdgConfig == "" && home != "" {
+		xdgConfig = filepath.Join(home, ".config")
+	}
+	configs := []string{filepath.Join(git.CommonDir(gitDir), "config")}
+	if home != "" {
+		configs = append(configs, filepath.Join(home, ".gitconfig"))
+	}
+	if xdgConfig != "" {
+		configs = append(configs, filepath.Join(xdgConfig, "git", "config"))
+	}
+	for _, config := range configs {
+		if value, ok := git.ConfigValue(config, "core", "excludesfile"); ok {
+			if strings.HasPrefix(value, "~/") && home != "" {
+				value = filepath.Join(home, value[2:])
+			}
+			return value
+		}
+	}
+	if xdgConfig == "" {
+		return ""
+	}
+	return filepath.Join(xdgConfig, "git", "ignore")
+}
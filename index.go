@@ -0,0 +1,128 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/svent/sift/index"
+	"github.com/svent/sift/search"
+)
+
+// indexFile returns the path of the index file for a directory. Index files
+// are kept in the user's cache directory, so they are not found by searches.
+func indexFile(root string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum([]byte(root))
+	return filepath.Join(cacheDir, "sift", "index", hex.EncodeToString(sum[:])), nil
+}
+
+// buildIndex builds or updates the index for a directory. The files are selected
+// like for a search, so e.g. --git and the file options are respected. Only files
+// that are new or modified since the last build are read.
+func buildIndex(dir string) error {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	fileinfo, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !fileinfo.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", dir)
+	}
+	name, err := indexFile(root)
+	if err != nil {
+		return err
+	}
+
+	options.TargetsOnly = true
+	if err := options.Apply(nil, []string{root}); err != nil {
+		return err
+	}
+	opts := options.searchOptions(nil)
+	opts.Zip = false
+	opts.Archives = false
+	searcher, err := search.New(opts)
+	if err != nil {
+		return err
+	}
+	var names []string
+	err = searcher.Search(context.Background(), []string{root}, func(result *search.Result) {
+		if rel, err := filepath.Rel(root, result.Target); err == nil {
+			names = append(names, filepath.ToSlash(rel))
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	prev, err := index.Open(name)
+	if err != nil && !os.IsNotExist(err) {
+		errorLogger.Printf("cannot use existing index for '%s', rebuilding it: %s\n", dir, err)
+	}
+	if prev != nil && prev.Root() != root {
+		prev = nil
+	}
+	ix, read := index.Build(root, names, prev)
+	if err := ix.Write(name); err != nil {
+		return err
+	}
+	fmt.Fprintf(global.outputFile, "indexed %d files in '%s' (%d files read, %d unchanged)\n", ix.Len(), root, read, ix.Len()-read)
+	return nil
+}
+
+// loadIndex returns the index of the directory containing all targets.
+// The index of the nearest directory with an index is used.
+func loadIndex(targets []string) (*index.Index, error) {
+	var ix *index.Index
+	for _, target := range targets {
+		path, err := filepath.Abs(target)
+		if err != nil {
+			return nil, err
+		}
+		root, name := "", ""
+		for lp := ""; path != lp; lp, path = path, filepath.Dir(path) {
+			if name, err = indexFile(path); err != nil {
+				return nil, err
+			}
+			if _, err := os.Stat(name); err == nil {
+				root = path
+				break
+			}
+		}
+		if root == "" {
+			return nil, fmt.Errorf("no index found for '%s', create one with --index-build", target)
+		}
+		if ix == nil {
+			if ix, err = index.Open(name); err != nil {
+				return nil, fmt.Errorf("cannot open index for '%s': %s", root, err)
+			}
+		} else if ix.Root() != root {
+			return nil, fmt.Errorf("targets are covered by different indexes ('%s' and '%s')", ix.Root(), root)
+		}
+	}
+	return ix, nil
+}
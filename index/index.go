@@ -0,0 +1,447 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+/*
+Package index provides a trigram index of the files of a directory tree.
+
+For each trigram (sequence of three bytes) the index holds the list of files
+containing it. The trigrams are built from the content of the files with
+ASCII letters converted to lowercase, so the index serves case sensitive
+and case insensitive searches. A Query derived from a regular expression
+selects the files that can contain a match.
+
+The size and modification time of each file are recorded when it is indexed,
+files modified since then have to be searched without using the index.
+*/
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// indexMagic identifies index files and their format version.
+const indexMagic = "sift trigram index 1\n"
+
+// File is an indexed file.
+type File struct {
+	ID int
+	// Name is the path of the file relative to the root of the index,
+	// using slashes as separator.
+	Name    string
+	Size    int64
+	ModTime int64
+}
+
+// Index is a trigram index of the files of a directory tree.
+type Index struct {
+	root  string
+	files []File
+	names map[string]int
+	// trigrams are sorted, postings holds the encoded list of files for each trigram
+	trigrams []uint32
+	postings [][]byte
+}
+
+// Root returns the directory the index was built for.
+func (ix *Index) Root() string {
+	return ix.root
+}
+
+// Len returns the number of indexed files.
+func (ix *Index) Len() int {
+	return len(ix.files)
+}
+
+// Lookup returns the indexed file with the given name relative to the root.
+func (ix *Index) Lookup(name string) (File, bool) {
+	id, ok := ix.names[name]
+	if !ok {
+		return File{}, false
+	}
+	return ix.files[id], true
+}
+
+// Current checks whether the file is unchanged since it was indexed.
+func (f File) Current(fileinfo os.FileInfo) bool {
+	return fileinfo.Size() == f.Size && fileinfo.ModTime().UnixNano() == f.ModTime
+}
+
+// Build builds an index for the files of root given by their names relative to
+// root. Files that are unchanged since prev was built are taken from prev
+// without reading them again, prev may be nil. Files that cannot be read are
+// not indexed. Build returns the index and the number of files read.
+func Build(root string, names []string, prev *Index) (*Index, int) {
+	ix := &Index{root: root, names: make(map[string]int)}
+	var reusedIDs []int
+	var changed []File
+	for _, name := range names {
+		if _, ok := ix.names[name]; ok {
+			continue
+		}
+		fileinfo, err := os.Stat(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil || !fileinfo.Mode().IsRegular() {
+			continue
+		}
+		ix.names[name] = -1
+		if prev != nil {
+			if f, ok := prev.Lookup(name); ok && f.Current(fileinfo) {
+				reusedIDs = append(reusedIDs, f.ID)
+				continue
+			}
+		}
+		changed = append(changed, File{Name: name, Size: fileinfo.Size(), ModTime: fileinfo.ModTime().UnixNano()})
+	}
+
+	// unchanged files keep their order, so their lists of files stay sorted
+	sort.Ints(reusedIDs)
+	if prev != nil && len(changed) == 0 && len(reusedIDs) == len(prev.files) {
+		for _, f := range prev.files {
+			ix.addFile(f)
+		}
+		ix.trigrams, ix.postings = prev.trigrams, prev.postings
+		return ix, 0
+	}
+	postings := newPostingLists()
+	if prev != nil {
+		newIDs := make([]int32, len(prev.files))
+		for i := range newIDs {
+			newIDs[i] = -1
+		}
+		for _, id := range reusedIDs {
+			f := prev.files[id]
+			newIDs[id] = int32(len(ix.files))
+			ix.addFile(f)
+		}
+		for i, trigram := range prev.trigrams {
+			var list []uint32
+			for _, id := range decodePostings(prev.postings[i]) {
+				if int(id) < len(newIDs) && newIDs[id] >= 0 {
+					list = append(list, uint32(newIDs[id]))
+				}
+			}
+			if len(list) > 0 {
+				postings.add(trigram, list...)
+			}
+		}
+	}
+
+	set := newTrigramSet()
+	read := 0
+	for _, f := range changed {
+		err := set.addFile(filepath.Join(root, filepath.FromSlash(f.Name)))
+		if err != nil {
+			set.reset()
+			continue
+		}
+		id := uint32(len(ix.files))
+		ix.addFile(f)
+		for _, trigram := range set.list {
+			postings.add(trigram, id)
+		}
+		set.reset()
+		read++
+	}
+	for name, id := range ix.names {
+		if id < 0 {
+			delete(ix.names, name)
+		}
+	}
+
+	ix.trigrams = make([]uint32, 0, len(postings.lists))
+	ix.postings = make([][]byte, 0, len(postings.lists))
+	for trigram, slot := range postings.slots {
+		if slot > 0 {
+			ix.trigrams = append(ix.trigrams, uint32(trigram))
+			ix.postings = append(ix.postings, encodePostings(postings.lists[slot-1]))
+		}
+	}
+	return ix, read
+}
+
+// lookupTrigram returns the encoded list of files containing a trigram.
+func (ix *Index) lookupTrigram(trigram uint32) []byte {
+	i := sort.Search(len(ix.trigrams), func(i int) bool { return ix.trigrams[i] >= trigram })
+	if i < len(ix.trigrams) && ix.trigrams[i] == trigram {
+		return ix.postings[i]
+	}
+	return nil
+}
+
+func (ix *Index) addFile(f File) {
+	f.ID = len(ix.files)
+	ix.files = append(ix.files, f)
+	ix.names[f.Name] = f.ID
+}
+
+// postingLists collects the list of files for each trigram.
+type postingLists struct {
+	// slots holds the index+1 of the list of each trigram, 0 if there is none
+	slots []int32
+	lists [][]uint32
+}
+
+func newPostingLists() *postingLists {
+	return &postingLists{slots: make([]int32, 1<<24)}
+}
+
+func (p *postingLists) add(trigram uint32, ids ...uint32) {
+	slot := p.slots[trigram]
+	if slot == 0 {
+		p.lists = append(p.lists, nil)
+		slot = int32(len(p.lists))
+		p.slots[trigram] = slot
+	}
+	p.lists[slot-1] = append(p.lists[slot-1], ids...)
+}
+
+// trigramSet collects the trigrams of a file.
+type trigramSet struct {
+	bits []uint64
+	list []uint32
+}
+
+func newTrigramSet() *trigramSet {
+	return &trigramSet{bits: make([]uint64, 1<<24/64)}
+}
+
+func (s *trigramSet) add(trigram uint32) {
+	if s.bits[trigram/64]&(1<<(trigram%64)) == 0 {
+		s.bits[trigram/64] |= 1 << (trigram % 64)
+		s.list = append(s.list, trigram)
+	}
+}
+
+func (s *trigramSet) reset() {
+	for _, trigram := range s.list {
+		s.bits[trigram/64] = 0
+	}
+	s.list = s.list[:0]
+}
+
+// addFile adds the trigrams of the lowercased content of a file.
+func (s *trigramSet) addFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var trigram uint32
+	buf := make([]byte, 64*1024)
+	n := 0
+	for {
+		length, err := f.Read(buf)
+		for _, b := range buf[:length] {
+			trigram = (trigram<<8 | uint32(toLower(b))) & (1<<24 - 1)
+			if n++; n >= 3 {
+				s.add(trigram)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func toLower(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// encodePostings encodes a sorted list of file IDs as differences to the previous ID.
+func encodePostings(list []uint32) []byte {
+	buf := make([]byte, 0, len(list)+binary.MaxVarintLen32)
+	tmp := make([]byte, binary.MaxVarintLen32)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp, uint64(len(list)))]...)
+	var last uint32
+	for _, id := range list {
+		buf = append(buf, tmp[:binary.PutUvarint(tmp, uint64(id-last))]...)
+		last = id
+	}
+	return buf
+}
+
+func decodePostings(data []byte) []uint32 {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return nil
+	}
+	data = data[n:]
+	list := make([]uint32, 0, count)
+	var id uint32
+	for i := uint64(0); i < count; i++ {
+		delta, n := binary.Uvarint(data)
+		if n <= 0 {
+			break
+		}
+		data = data[n:]
+		id += uint32(delta)
+		list = append(list, id)
+	}
+	return list
+}
+
+// Write writes the index to a file. The file is replaced atomically.
+func (ix *Index) Write(name string) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	tmpfile, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmpfile)
+	w.WriteString(indexMagic)
+	writeString(w, ix.root)
+	writeUvarint(w, uint64(len(ix.files)))
+	for _, f := range ix.files {
+		writeString(w, f.Name)
+		writeUvarint(w, uint64(f.Size))
+		writeVarint(w, f.ModTime)
+	}
+	writeUvarint(w, uint64(len(ix.trigrams)))
+	last := uint32(0)
+	for i, trigram := range ix.trigrams {
+		writeUvarint(w, uint64(trigram-last))
+		writeUvarint(w, uint64(len(ix.postings[i])))
+		w.Write(ix.postings[i])
+		last = trigram
+	}
+
+	err = w.Flush()
+	if closeErr := tmpfile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpfile.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmpfile.Name())
+	}
+	return err
+}
+
+func writeUvarint(w *bufio.Writer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	w.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+func writeVarint(w *bufio.Writer, v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	w.Write(tmp[:binary.PutVarint(tmp[:], v)])
+}
+
+func writeString(w *bufio.Writer, s string) {
+	writeUvarint(w, uint64(len(s)))
+	w.WriteString(s)
+}
+
+// ErrFormat is returned by Open for files that are not valid index files.
+var ErrFormat = errors.New("invalid index file")
+
+// Open reads an index file written by Write.
+func Open(name string) (*Index, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(indexMagic)) {
+		return nil, ErrFormat
+	}
+	r := &reader{data: data[len(indexMagic):]}
+	ix := &Index{root: r.string(), names: make(map[string]int)}
+	fileCount := r.uvarint()
+	for i := uint64(0); i < fileCount && r.err == nil; i++ {
+		f := File{Name: r.string(), Size: int64(r.uvarint()), ModTime: r.varint()}
+		ix.addFile(f)
+	}
+	trigramCount := r.uvarint()
+	if trigramCount > uint64(len(r.data)) {
+		r.fail()
+	}
+	ix.trigrams = make([]uint32, 0, trigramCount)
+	ix.postings = make([][]byte, 0, trigramCount)
+	trigram := uint32(0)
+	for i := uint64(0); i < trigramCount && r.err == nil; i++ {
+		trigram += uint32(r.uvarint())
+		ix.trigrams = append(ix.trigrams, trigram)
+		ix.postings = append(ix.postings, r.bytes(int(r.uvarint())))
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("cannot read index file '%s': %s", name, r.err)
+	}
+	return ix, nil
+}
+
+// reader reads the encoded values of an index file.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *reader) varint() int64 {
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *reader) bytes(n int) []byte {
+	if n < 0 || n > len(r.data) {
+		r.fail()
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) string() string {
+	return string(r.bytes(int(r.uvarint())))
+}
+
+func (r *reader) fail() {
+	if r.err == nil {
+		r.err = ErrFormat
+	}
+	r.data = nil
+}
@@ -0,0 +1,405 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package index
+
+import (
+	"regexp/syntax"
+	"unicode"
+	"unicode/utf8"
+)
+
+// QueryOp is the operator of a Query.
+type QueryOp int
+
+const (
+	// QueryAll selects all files.
+	QueryAll QueryOp = iota
+	// QueryNone selects no files.
+	QueryNone
+	// QueryAnd selects the files containing all trigrams and matching all sub queries.
+	QueryAnd
+	// QueryOr selects the files containing any trigram or matching any sub query.
+	QueryOr
+)
+
+// Query selects the files that can contain a match of a regular expression.
+type Query struct {
+	Op       QueryOp
+	Trigrams []string
+	Sub      []*Query
+}
+
+// maxExact is the maximum number of strings tracked as the exact set of
+// strings a part of a regular expression matches.
+const maxExact = 64
+
+var (
+	allQuery  = &Query{Op: QueryAll}
+	noneQuery = &Query{Op: QueryNone}
+)
+
+// RegexpQuery returns the query for the files that can contain a match of re.
+func RegexpQuery(re *syntax.Regexp) *Query {
+	return analyze(re.Simplify()).query()
+}
+
+// OrQuery returns the query for the files selected by any of the queries.
+func OrQuery(queries ...*Query) *Query {
+	q := noneQuery
+	for _, sub := range queries {
+		q = orQuery(q, sub)
+	}
+	return q
+}
+
+func andQuery(a *Query, b *Query) *Query {
+	switch {
+	case a.Op == QueryNone || b.Op == QueryNone:
+		return noneQuery
+	case a.Op == QueryAll:
+		return b
+	case b.Op == QueryAll:
+		return a
+	}
+	return combineQueries(QueryAnd, a, b)
+}
+
+func orQuery(a *Query, b *Query) *Query {
+	switch {
+	case a.Op == QueryAll || b.Op == QueryAll:
+		return allQuery
+	case a.Op == QueryNone:
+		return b
+	case b.Op == QueryNone:
+		return a
+	}
+	return combineQueries(QueryOr, a, b)
+}
+
+// combineQueries combines queries, merging operands with the same operator.
+func combineQueries(op QueryOp, queries ...*Query) *Query {
+	q := &Query{Op: op}
+	for _, sub := range queries {
+		if sub.Op == op {
+			q.Trigrams = append(q.Trigrams, sub.Trigrams...)
+			q.Sub = append(q.Sub, sub.Sub...)
+		} else {
+			q.Sub = append(q.Sub, sub)
+		}
+	}
+	return q
+}
+
+// stringQuery returns the query for the files containing s.
+func stringQuery(s string) *Query {
+	if len(s) < 3 {
+		return allQuery
+	}
+	q := &Query{Op: QueryAnd}
+	for i := 0; i+3 <= len(s); i++ {
+		q.Trigrams = append(q.Trigrams, s[i:i+3])
+	}
+	return q
+}
+
+// regexpInfo describes what a part of a regular expression matches: the exact
+// set of strings if known, otherwise a query for the files containing a match.
+type regexpInfo struct {
+	// exact is nil if the set of strings is unknown or too large
+	exact []string
+	match *Query
+}
+
+var anyInfo = regexpInfo{match: allQuery}
+
+func exactInfo(strings ...string) regexpInfo {
+	return regexpInfo{exact: strings}
+}
+
+// query returns the query for the files containing a match.
+func (info regexpInfo) query() *Query {
+	if info.exact == nil {
+		return info.match
+	}
+	q := noneQuery
+	for _, s := range info.exact {
+		q = orQuery(q, stringQuery(s))
+	}
+	return q
+}
+
+func analyze(re *syntax.Regexp) regexpInfo {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return regexpInfo{match: noneQuery}
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText,
+		syntax.OpEndText, syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return exactInfo("")
+	case syntax.OpLiteral:
+		return literalInfo(re.Rune, re.Flags&syntax.FoldCase != 0)
+	case syntax.OpCharClass:
+		return charClassInfo(re.Rune)
+	case syntax.OpCapture:
+		return analyze(re.Sub[0])
+	case syntax.OpPlus:
+		return regexpInfo{match: analyze(re.Sub[0]).query()}
+	case syntax.OpRepeat:
+		if re.Min == 0 {
+			return anyInfo
+		}
+		return regexpInfo{match: analyze(re.Sub[0]).query()}
+	case syntax.OpConcat:
+		infos := make([]regexpInfo, len(re.Sub))
+		for i, sub := range re.Sub {
+			infos[i] = analyze(sub)
+		}
+		return concatInfo(infos)
+	case syntax.OpAlternate:
+		infos := make([]regexpInfo, len(re.Sub))
+		for i, sub := range re.Sub {
+			infos[i] = analyze(sub)
+		}
+		return alternateInfo(infos)
+	}
+	// any character, star, quest
+	return anyInfo
+}
+
+// literalInfo returns the info for a literal. The index contains lowercased
+// content, letters folding to non-ASCII letters (e.g. 'k' and the Kelvin sign)
+// can match anything.
+func literalInfo(runes []rune, foldCase bool) regexpInfo {
+	if !foldCase {
+		return exactInfo(lowerASCII(string(runes)))
+	}
+	var infos []regexpInfo
+	start := 0
+	for i, r := range runes {
+		if foldsToNonASCII(r) {
+			infos = append(infos, exactInfo(lowerASCII(string(runes[start:i]))), anyInfo)
+			start = i + 1
+		}
+	}
+	infos = append(infos, exactInfo(lowerASCII(string(runes[start:]))))
+	return concatInfo(infos)
+}
+
+func foldsToNonASCII(r rune) bool {
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if r >= utf8.RuneSelf || f >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+func lowerASCII(s string) string {
+	b := []byte(s)
+	for i := range b {
+		b[i] = toLower(b[i])
+	}
+	return string(b)
+}
+
+// charClassInfo returns the info for a character class given as rune ranges.
+func charClassInfo(ranges []rune) regexpInfo {
+	exact := []string{}
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(ranges); i += 2 {
+		if int(ranges[i+1]-ranges[i]) >= maxExact {
+			return anyInfo
+		}
+		for r := ranges[i]; r <= ranges[i+1]; r++ {
+			s := lowerASCII(string(r))
+			if !seen[s] {
+				seen[s] = true
+				exact = append(exact, s)
+			}
+		}
+		if len(exact) > maxExact {
+			return anyInfo
+		}
+	}
+	return exactInfo(exact...)
+}
+
+// concatInfo returns the info for a concatenation. The exact sets are combined
+// as long as they do not grow too large.
+func concatInfo(infos []regexpInfo) regexpInfo {
+	q := allQuery
+	exact := []string{""}
+	for _, info := range infos {
+		if info.exact == nil {
+			q = andQuery(q, andQuery(exactInfo(exact...).query(), info.match))
+			exact = []string{""}
+			continue
+		}
+		if len(exact)*len(info.exact) > maxExact {
+			q = andQuery(q, exactInfo(exact...).query())
+			exact = info.exact
+			continue
+		}
+		combined := make([]string, 0, len(exact)*len(info.exact))
+		for _, prefix := range exact {
+			for _, suffix := range info.exact {
+				combined = append(combined, prefix+suffix)
+			}
+		}
+		exact = combined
+	}
+	if q.Op == QueryAll {
+		return exactInfo(exact...)
+	}
+	return regexpInfo{match: andQuery(q, exactInfo(exact...).query())}
+}
+
+// alternateInfo returns the info for an alternation.
+func alternateInfo(infos []regexpInfo) regexpInfo {
+	exact := []string{}
+	seen := make(map[string]bool)
+	for _, info := range infos {
+		if info.exact == nil || len(exact)+len(info.exact) > maxExact {
+			exact = nil
+			break
+		}
+		for _, s := range info.exact {
+			if !seen[s] {
+				seen[s] = true
+				exact = append(exact, s)
+			}
+		}
+	}
+	if exact != nil {
+		return exactInfo(exact...)
+	}
+	q := noneQuery
+	for _, info := range infos {
+		q = orQuery(q, info.query())
+	}
+	return regexpInfo{match: q}
+}
+
+// fileSet is a sorted set of file IDs.
+type fileSet struct {
+	all bool
+	ids []uint32
+}
+
+// Candidates returns for each file, indexed by File.ID, whether it is
+// selected by the query.
+func (ix *Index) Candidates(q *Query) []bool {
+	set := ix.eval(q)
+	candidates := make([]bool, len(ix.files))
+	if set.all {
+		for i := range candidates {
+			candidates[i] = true
+		}
+	}
+	for _, id := range set.ids {
+		if int(id) < len(candidates) {
+			candidates[id] = true
+		}
+	}
+	return candidates
+}
+
+func (ix *Index) eval(q *Query) fileSet {
+	switch q.Op {
+	case QueryAll:
+		return fileSet{all: true}
+	case QueryAnd:
+		set := fileSet{all: true}
+		for _, trigram := range q.Trigrams {
+			set = intersectFileSets(set, ix.trigramFiles(trigram))
+			if !set.all && len(set.ids) == 0 {
+				return set
+			}
+		}
+		for _, sub := range q.Sub {
+			set = intersectFileSets(set, ix.eval(sub))
+			if !set.all && len(set.ids) == 0 {
+				return set
+			}
+		}
+		return set
+	case QueryOr:
+		var set fileSet
+		for _, trigram := range q.Trigrams {
+			set = unionFileSets(set, ix.trigramFiles(trigram))
+		}
+		for _, sub := range q.Sub {
+			set = unionFileSets(set, ix.eval(sub))
+			if set.all {
+				return set
+			}
+		}
+		return set
+	}
+	return fileSet{}
+}
+
+func (ix *Index) trigramFiles(trigram string) fileSet {
+	t := uint32(trigram[0])<<16 | uint32(trigram[1])<<8 | uint32(trigram[2])
+	return fileSet{ids: decodePostings(ix.lookupTrigram(t))}
+}
+
+func intersectFileSets(a fileSet, b fileSet) fileSet {
+	switch {
+	case a.all:
+		return b
+	case b.all:
+		return a
+	}
+	var ids []uint32
+	for i, j := 0, 0; i < len(a.ids) && j < len(b.ids); {
+		switch {
+		case a.ids[i] < b.ids[j]:
+			i++
+		case a.ids[i] > b.ids[j]:
+			j++
+		default:
+			ids = append(ids, a.ids[i])
+			i++
+			j++
+		}
+	}
+	return fileSet{ids: ids}
+}
+
+func unionFileSets(a fileSet, b fileSet) fileSet {
+	if a.all || b.all {
+		return fileSet{all: true}
+	}
+	ids := make([]uint32, 0, len(a.ids)+len(b.ids))
+	i, j := 0, 0
+	for i < len(a.ids) && j < len(b.ids) {
+		switch {
+		case a.ids[i] < b.ids[j]:
+			ids = append(ids, a.ids[i])
+			i++
+		case a.ids[i] > b.ids[j]:
+			ids = append(ids, b.ids[j])
+			j++
+		default:
+			ids = append(ids, a.ids[i])
+			i++
+			j++
+		}
+	}
+	ids = append(ids, a.ids[i:]...)
+	ids = append(ids, b.ids[j:]...)
+	return fileSet{ids: ids}
+}
@@ -29,14 +29,26 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/svent/sift/search"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
 type Options struct {
 	BinarySkip          bool   `long:"binary-skip" description:"skip files that seem to be binary"`
 	BinaryAsText        bool   `short:"a" long:"binary-text" description:"process files that seem to be binary as text"`
+	Archives            bool   `long:"archives" description:"search inside zip, jar and tar archives (default: off)"`
+	NoArchives          func() `long:"no-archives" description:"do not search inside archives" json:"-"`
+	ArchiveDepth        int    `long:"archive-depth" description:"maximum nesting depth of archives (default: 3)" value-name:"NUM" default-mask:"-"`
+	AllRevs             bool   `long:"all-revs" description:"search the files of all commits reachable from the git refs, files with the same content are searched once" json:"-"`
 	Blocksize           string `long:"blocksize" description:"blocksize in bytes (with optional suffix K|M)"`
+	Backup              string `long:"backup" description:"with --write, keep a copy of modified files with the file name + SUFFIX" value-name:"SUFFIX" json:"-"`
+	Changed             bool   `long:"changed" description:"search only the files modified or added in the git work tree since the last commit" json:"-"`
+	ChangedLines        bool   `long:"changed-lines" description:"with --changed or --changed-since, only show matches on lines added or modified" json:"-"`
+	ChangedSince        string `long:"changed-since" description:"search only the files modified or added in the git work tree since revision REV" value-name:"REV" json:"-"`
 	Color               string
 	ColorFunc           func()   `long:"color" description:"enable colored output (default: auto)" json:"-"`
 	NoColorFunc         func()   `long:"no-color" description:"disable colored output" json:"-"`
@@ -46,11 +58,14 @@ type Options struct {
 	ContextBefore       int      `short:"B" long:"context-before" description:"show NUM context lines before match" value-name:"NUM" json:"-"`
 	Cores               int      `short:"j" long:"cores" description:"limit used CPU Cores (default: 0 = all)" default-mask:"-"`
 	Count               bool     `short:"c" long:"count" description:"print count of matches per file" json:"-"`
+	DryRun              bool     `long:"dry-run" description:"with --write, show the changes as unified diff instead of modifying files" json:"-"`
 	IncludeDirs         []string `long:"dirs" description:"recurse only into directories whose name matches GLOB" value-name:"GLOB" default-mask:"-"`
 	ErrShowLineLength   bool     `long:"err-show-line-length" description:"show all line length errors"`
 	ErrSkipLineLength   bool     `long:"err-skip-line-length" description:"skip line length errors"`
+	DebugSelection      bool     `long:"debug-selection" description:"print the decision for every file and directory found while recursing to stderr" json:"-"`
 	ExcludeDirs         []string `long:"exclude-dirs" description:"do not recurse into directories whose name matches GLOB" value-name:"GLOB" default-mask:"-"`
 	IncludeExtensions   string   `short:"x" long:"ext" description:"limit search to specific file extensions (comma-separated)" default-mask:"-"`
+	Explain             string   `long:"explain" description:"show which options select or skip PATH and the directories leading to it, do not search" value-name:"PATH" json:"-"`
 	ExcludeExtensions   string   `short:"X" long:"exclude-ext" description:"exclude specific file extensions (comma-separated)" default-mask:"-"`
 	IncludeFiles        []string `long:"files" description:"search only files whose name matches GLOB" value-name:"GLOB" default-mask:"-"`
 	ExcludeFiles        []string `long:"exclude-files" description:"do not select files whose name matches GLOB while recursing" value-name:"GLOB" default-mask:"-"`
@@ -63,75 +78,101 @@ type Options struct {
 	AddCustomTypes      []string `long:"add-type" description:"add custom type (see --list-types for format)" default-mask:"-" json:"-"`
 	DelCustomTypes      []string `long:"del-type" description:"remove custom type" default-mask:"-" json:"-"`
 	CustomTypes         map[string]string
-	FieldSeparator      string   `long:"field-sep" description:"column separator (default: \":\")" default-mask:"-"`
-	FilesWithMatches    bool     `short:"l" long:"files-with-matches" description:"list files containing matches"`
-	FilesWithoutMatch   bool     `short:"L" long:"files-without-match" description:"list files containing no match"`
-	FollowSymlinks      bool     `long:"follow" description:"follow symlinks"`
-	Git                 bool     `long:"git" description:"respect .gitignore files and skip .git directories"`
-	GroupByFile         bool     `long:"group" description:"group output by file (default: off)"`
-	NoGroupByFile       func()   `long:"no-group" description:"do not group output by file" json:"-"`
-	IgnoreCase          bool     `short:"i" long:"ignore-case" description:"case insensitive (default: off)"`
-	NoIgnoreCase        func()   `short:"I" long:"no-ignore-case" description:"disable case insensitive" json:"-"`
-	SmartCase           bool     `short:"s" long:"smart-case" description:"case insensitive unless pattern contains uppercase characters (default: off)"`
-	NoSmartCase         func()   `short:"S" long:"no-smart-case" description:"disable smart case" json:"-"`
-	NoConfig            bool     `long:"no-conf" description:"do not load config files" json:"-"`
-	InvertMatch         bool     `short:"v" long:"invert-match" description:"select non-matching lines" json:"-"`
-	Limit               int64    `long:"limit" description:"only show first NUM matches per file" value-name:"NUM" default-mask:"-"`
-	Literal             bool     `short:"Q" long:"literal" description:"treat pattern as literal, quote meta characters"`
-	Multiline           bool     `short:"m" long:"multiline" description:"multiline parsing (default: off)"`
-	NoMultiline         func()   `short:"M" long:"no-multiline" description:"disable multiline parsing" json:"-"`
-	OnlyMatching        bool     `long:"only-matching" description:"only show the matching part of a line" json:"-"`
-	Output              string   `short:"o" long:"output" description:"write output to the specified file or network connection" value-name:"FILE|tcp://HOST:PORT" json:"-"`
-	OutputLimit         int      `long:"output-limit" description:"limit output length per found match" default-mask:"-"`
-	OutputSeparator     string   `long:"output-sep" description:"output separator (default: \"\\n\")" default-mask:"-" json:"-"`
-	OutputUnixPath      bool     `long:"output-unixpath" description:"output file paths in unix format ('/' as path separator)"`
-	Patterns            []string `short:"e" long:"regexp" description:"add pattern PATTERN to the search" value-name:"PATTERN" default-mask:"-" json:"-"`
-	PatternFile         string   `short:"f" long:"regexp-file" description:"search for patterns contained in FILE (one per line)" value-name:"FILE" default-mask:"-" json:"-"`
-	PrintConfig         bool     `long:"print-config" description:"print config for loaded configs + given command line arguments" json:"-"`
-	Quiet               bool     `short:"q" long:"quiet" description:"suppress output, exit with return code zero if any match is found" json:"-"`
-	Recursive           bool     `short:"r" long:"recursive" description:"recurse into directories (default: on)"`
-	NoRecursive         func()   `short:"R" long:"no-recursive" description:"do not recurse into directories" json:"-"`
-	Replace             string   `long:"replace" description:"replace numbered or named (?P<name>pattern) capture groups. Use ${1}, ${2}, $name, ... for captured submatches" json:"-"`
+	FileTimeout         time.Duration `long:"file-timeout" description:"skip files taking longer than DURATION to search (e.g. 500ms, 10s)" value-name:"DURATION" default-mask:"-" json:"-"`
+	FieldSeparator      string        `long:"field-sep" description:"column separator (default: \":\")" default-mask:"-"`
+	FilesWithMatches    bool          `short:"l" long:"files-with-matches" description:"list files containing matches"`
+	FilesWithoutMatch   bool          `short:"L" long:"files-without-match" description:"list files containing no match"`
+	FollowSymlinks      bool          `long:"follow" description:"follow symlinks"`
+	Git                 bool          `long:"git" description:"respect .gitignore, .ignore and .siftignore files as well as the exclude files of git repositories and skip .git directories"`
+	GitTracked          bool          `long:"git-tracked" description:"search the files tracked in the git index instead of recursing into directories" json:"-"`
+	GitUntracked        bool          `long:"git-untracked" description:"search the tracked files and the untracked files that are not ignored (implies --git-tracked)" json:"-"`
+	GroupByFile         bool          `long:"group" description:"group output by file (default: off)"`
+	NoGroupByFile       func()        `long:"no-group" description:"do not group output by file" json:"-"`
+	Index               bool          `long:"index" description:"use the index built with --index-build to skip files that cannot contain a match" json:"-"`
+	IndexBuild          string        `long:"index-build" description:"build or update the trigram index for DIR, respecting --git and the file options" value-name:"DIR" json:"-"`
+	IgnoreCase          bool          `short:"i" long:"ignore-case" description:"case insensitive (default: off)"`
+	NoIgnoreCase        func()        `short:"I" long:"no-ignore-case" description:"disable case insensitive" json:"-"`
+	SmartCase           bool          `short:"s" long:"smart-case" description:"case insensitive unless pattern contains uppercase characters (default: off)"`
+	NoSmartCase         func()        `short:"S" long:"no-smart-case" description:"disable smart case" json:"-"`
+	NoConfig            bool          `long:"no-conf" description:"do not load config files" json:"-"`
+	InvertMatch         bool          `short:"v" long:"invert-match" description:"select non-matching lines" json:"-"`
+	JSON                bool          `long:"json" description:"print results as JSON Lines (one JSON object per event)" json:"-"`
+	Limit               int64         `long:"limit" description:"only show first NUM matches per file" value-name:"NUM" default-mask:"-"`
+	MaxLineLength       string        `long:"max-line-length" description:"grow the buffer up to SIZE for files with lines longer than the blocksize (with optional suffix K|M)" value-name:"SIZE" default-mask:"-"`
+	MaxFiles            int64         `long:"max-files" description:"stop the search after NUM files with matches" value-name:"NUM" default-mask:"-" json:"-"`
+	Literal             bool          `short:"Q" long:"literal" description:"treat pattern as literal, quote meta characters"`
+	Mmap                bool          `long:"mmap" description:"search regular files by mapping them into memory (Linux only), lines are not limited by the blocksize"`
+	Multiline           bool          `short:"m" long:"multiline" description:"multiline parsing (default: off)"`
+	NoMultiline         func()        `short:"M" long:"no-multiline" description:"disable multiline parsing" json:"-"`
+	OnlyMatching        bool          `long:"only-matching" description:"only show the matching part of a line" json:"-"`
+	Output              string        `short:"o" long:"output" description:"write output to the specified file or network connection" value-name:"FILE|tcp://HOST:PORT" json:"-"`
+	OutputLimit         int           `long:"output-limit" description:"limit output length per found match" default-mask:"-"`
+	OutputSeparator     string        `long:"output-sep" description:"output separator (default: \"\\n\")" default-mask:"-" json:"-"`
+	OutputUnixPath      bool          `long:"output-unixpath" description:"output file paths in unix format ('/' as path separator)"`
+	Patch               bool          `long:"patch" description:"print the result of --replace as patch in unified diff format" json:"-"`
+	PatchContext        int           `long:"patch-context" description:"number of context lines in patches and with --dry-run (default: 3)" value-name:"NUM" json:"-" default-mask:"-"`
+	Patterns            []string      `short:"e" long:"regexp" description:"add pattern PATTERN to the search" value-name:"PATTERN" default-mask:"-" json:"-"`
+	PatternFile         string        `short:"f" long:"regexp-file" description:"search for patterns contained in FILE (one per line)" value-name:"FILE" default-mask:"-" json:"-"`
+	PrintConfig         bool          `long:"print-config" description:"print config for loaded configs + given command line arguments" json:"-"`
+	Query               string        `long:"query" description:"search for a query combining patterns with and, or, not and parentheses, e.g. 'foo and not (bar or file:baz)'. Patterns can be prefixed with the scope line: (default), file: or window:NUM:" value-name:"QUERY" default-mask:"-" unquote:"false" json:"-"`
+	Quiet               bool          `short:"q" long:"quiet" description:"suppress output, exit with return code zero if any match is found" json:"-"`
+	Recursive           bool          `short:"r" long:"recursive" description:"recurse into directories (default: on)"`
+	NoRecursive         func()        `short:"R" long:"no-recursive" description:"do not recurse into directories" json:"-"`
+	Replace             string        `long:"replace" description:"replace numbered or named (?P<name>pattern) capture groups. Use ${1}, ${2}, $name, ... for captured submatches" json:"-"`
+	Revisions           []string      `long:"rev" description:"search the files of git revision REV instead of the work tree (e.g. HEAD~2, v1.0 or a commit)" value-name:"REV" default-mask:"-" json:"-"`
+	SARIF               bool          `long:"sarif" description:"print results as a SARIF 2.1.0 report" json:"-"`
 	ShowFilename        string
-	ShowFilenameFunc    func() `long:"filename" description:"enforce printing the filename before results (default: auto)" json:"-"`
-	NoShowFilenameFunc  func() `long:"no-filename" description:"disable printing the filename before results" json:"-"`
-	ShowLineNumbers     bool   `short:"n" long:"line-number" description:"show line numbers (default: off)"`
-	NoShowLineNumbers   func() `short:"N" long:"no-line-number" description:"do not show line numbers" json:"-"`
-	ShowColumnNumbers   bool   `long:"column" description:"show column numbers"`
-	NoShowColumnNumbers func() `long:"no-column" description:"do not show column numbers" json:"-"`
-	ShowByteOffset      bool   `long:"byte-offset" description:"show the byte offset before each output line"`
-	NoShowByteOffset    func() `long:"no-byte-offset" description:"do not show the byte offset before each output line" json:"-"`
-	Stats               bool   `long:"stats" description:"show statistics"`
-	TargetsOnly         bool   `long:"targets" description:"only list selected files, do not search"`
-	ListTypes           bool   `long:"list-types" description:"list available file types" json:"-" default-mask:"-"`
-	Version             func() `short:"V" long:"version" description:"show version and license information" json:"-"`
-	WordRegexp          bool   `short:"w" long:"word-regexp" description:"only match on ASCII word boundaries"`
-	WriteConfig         bool   `long:"write-config" description:"save config for loaded configs + given command line arguments" json:"-"`
-	Zip                 bool   `short:"z" long:"zip" description:"search content of compressed .gz files (default: off)"`
-	NoZip               func() `short:"Z" long:"no-zip" description:"do not search content of compressed .gz files" json:"-"`
+	ShowFilenameFunc    func()        `long:"filename" description:"enforce printing the filename before results (default: auto)" json:"-"`
+	NoShowFilenameFunc  func()        `long:"no-filename" description:"disable printing the filename before results" json:"-"`
+	ShowLineNumbers     bool          `short:"n" long:"line-number" description:"show line numbers (default: off)"`
+	NoShowLineNumbers   func()        `short:"N" long:"no-line-number" description:"do not show line numbers" json:"-"`
+	ShowColumnNumbers   bool          `long:"column" description:"show column numbers"`
+	NoShowColumnNumbers func()        `long:"no-column" description:"do not show column numbers" json:"-"`
+	ShowByteOffset      bool          `long:"byte-offset" description:"show the byte offset before each output line"`
+	NoShowByteOffset    func()        `long:"no-byte-offset" description:"do not show the byte offset before each output line" json:"-"`
+	SplitLongLines      bool          `long:"split-long-lines" description:"search lines longer than the buffer in overlapping windows instead of skipping the file" json:"-"`
+	Stats               bool          `long:"stats" description:"show statistics"`
+	TargetsOnly         bool          `long:"targets" description:"only list selected files, do not search"`
+	Timeout             time.Duration `long:"timeout" description:"abort the search after DURATION (e.g. 30s, 5m)" value-name:"DURATION" default-mask:"-" json:"-"`
+	ListTypes           bool          `long:"list-types" description:"list available file types" json:"-" default-mask:"-"`
+	UnicodeCase         bool          `long:"unicode-case" description:"use Unicode case folding for case insensitive search (default: auto, on for non-ASCII patterns)"`
+	Version             func()        `short:"V" long:"version" description:"show version and license information" json:"-"`
+	WordRegexp          bool          `short:"w" long:"word-regexp" description:"only match on ASCII word boundaries"`
+	Write               bool          `long:"write" description:"write the replacements of --replace to the files" json:"-"`
+	WriteConfig         bool          `long:"write-config" description:"save config for loaded configs + given command line arguments" json:"-"`
+	Zip                 bool          `short:"z" long:"zip" description:"search content of compressed files (gzip, bzip2, xz, zstd, lz4) (default: off)"`
+	NoZip               func()        `short:"Z" long:"no-zip" description:"do not search content of compressed files" json:"-"`
 
 	FileConditions struct {
-		FileMatches     []string `long:"file-matches" description:"only show matches if file also matches PATTERN" value-name:"PATTERN"`
-		LineMatches     []string `long:"line-matches" description:"only show matches if line NUM matches PATTERN" value-name:"NUM:PATTERN"`
-		RangeMatches    []string `long:"range-matches" description:"only show matches if lines X-Y match PATTERN" value-name:"X:Y:PATTERN"`
-		NotFileMatches  []string `long:"not-file-matches" description:"only show matches if file does not match PATTERN" value-name:"PATTERN"`
-		NotLineMatches  []string `long:"not-line-matches" description:"only show matches if line NUM does not match PATTERN" value-name:"NUM:PATTERN"`
-		NotRangeMatches []string `long:"not-range-matches" description:"only show matches if lines X-Y do not match PATTERN" value-name:"X:Y:PATTERN"`
+		FileMatches        []string `long:"file-matches" description:"only show matches if file also matches PATTERN" value-name:"PATTERN"`
+		FileMatchesAtLeast []string `long:"file-matches-at-least" description:"only show matches if file contains at least NUM matches of PATTERN" value-name:"NUM:PATTERN"`
+		FileMatchesAtMost  []string `long:"file-matches-at-most" description:"only show matches if file contains at most NUM matches of PATTERN" value-name:"NUM:PATTERN"`
+		LineMatches        []string `long:"line-matches" description:"only show matches if line NUM matches PATTERN" value-name:"NUM:PATTERN"`
+		RangeMatches       []string `long:"range-matches" description:"only show matches if lines X-Y match PATTERN" value-name:"X:Y:PATTERN"`
+		NotFileMatches     []string `long:"not-file-matches" description:"only show matches if file does not match PATTERN" value-name:"PATTERN"`
+		NotLineMatches     []string `long:"not-line-matches" description:"only show matches if line NUM does not match PATTERN" value-name:"NUM:PATTERN"`
+		NotRangeMatches    []string `long:"not-range-matches" description:"only show matches if lines X-Y do not match PATTERN" value-name:"X:Y:PATTERN"`
 	} `group:"File Condition options" json:"-"`
 
 	MatchConditions struct {
-		Preceded            []string `long:"preceded-by" description:"only show matches preceded by PATTERN" value-name:"PATTERN"`
-		Followed            []string `long:"followed-by" description:"only show matches followed by PATTERN" value-name:"PATTERN"`
-		Surrounded          []string `long:"surrounded-by" description:"only show matches surrounded by PATTERN" value-name:"PATTERN"`
-		PrecededWithin      []string `long:"preceded-within" description:"only show matches preceded by PATTERN within NUM lines" value-name:"NUM:PATTERN"`
-		FollowedWithin      []string `long:"followed-within" description:"only show matches followed by PATTERN within NUM lines" value-name:"NUM:PATTERN"`
-		SurroundedWithin    []string `long:"surrounded-within" description:"only show matches surrounded by PATTERN within NUM lines" value-name:"NUM:PATTERN"`
-		NotPreceded         []string `long:"not-preceded-by" description:"only show matches not preceded by PATTERN" value-name:"PATTERN"`
-		NotFollowed         []string `long:"not-followed-by" description:"only show matches not followed by PATTERN" value-name:"PATTERN"`
-		NotSurrounded       []string `long:"not-surrounded-by" description:"only show matches not surrounded by PATTERN" value-name:"PATTERN"`
-		NotPrecededWithin   []string `long:"not-preceded-within" description:"only show matches not preceded by PATTERN within NUM lines" value-name:"NUM:PATTERN"`
-		NotFollowedWithin   []string `long:"not-followed-within" description:"only show matches not followed by PATTERN within NUM lines" value-name:"NUM:PATTERN"`
-		NotSurroundedWithin []string `long:"not-surrounded-within" description:"only show matches not surrounded by PATTERN within NUM lines" value-name:"NUM:PATTERN"`
+		Preceded                 []string `long:"preceded-by" description:"only show matches preceded by PATTERN" value-name:"PATTERN"`
+		Followed                 []string `long:"followed-by" description:"only show matches followed by PATTERN" value-name:"PATTERN"`
+		Surrounded               []string `long:"surrounded-by" description:"only show matches surrounded by PATTERN" value-name:"PATTERN"`
+		PrecededWithin           []string `long:"preceded-within" description:"only show matches preceded by PATTERN within NUM lines" value-name:"NUM:PATTERN"`
+		FollowedWithin           []string `long:"followed-within" description:"only show matches followed by PATTERN within NUM lines" value-name:"NUM:PATTERN"`
+		SurroundedWithin         []string `long:"surrounded-within" description:"only show matches surrounded by PATTERN within NUM lines" value-name:"NUM:PATTERN"`
+		NotPreceded              []string `long:"not-preceded-by" description:"only show matches not preceded by PATTERN" value-name:"PATTERN"`
+		NotFollowed              []string `long:"not-followed-by" description:"only show matches not followed by PATTERN" value-name:"PATTERN"`
+		NotSurrounded            []string `long:"not-surrounded-by" description:"only show matches not surrounded by PATTERN" value-name:"PATTERN"`
+		NotPrecededWithin        []string `long:"not-preceded-within" description:"only show matches not preceded by PATTERN within NUM lines" value-name:"NUM:PATTERN"`
+		NotFollowedWithin        []string `long:"not-followed-within" description:"only show matches not followed by PATTERN within NUM lines" value-name:"NUM:PATTERN"`
+		NotSurroundedWithin      []string `long:"not-surrounded-within" description:"only show matches not surrounded by PATTERN within NUM lines" value-name:"NUM:PATTERN"`
+		PrecededWithinBytes      []string `long:"preceded-within-bytes" description:"only show matches preceded by PATTERN within NUM bytes" value-name:"NUM:PATTERN"`
+		FollowedWithinBytes      []string `long:"followed-within-bytes" description:"only show matches followed by PATTERN within NUM bytes" value-name:"NUM:PATTERN"`
+		SurroundedWithinBytes    []string `long:"surrounded-within-bytes" description:"only show matches surrounded by PATTERN within NUM bytes" value-name:"NUM:PATTERN"`
+		NotPrecededWithinBytes   []string `long:"not-preceded-within-bytes" description:"only show matches not preceded by PATTERN within NUM bytes" value-name:"NUM:PATTERN"`
+		NotFollowedWithinBytes   []string `long:"not-followed-within-bytes" description:"only show matches not followed by PATTERN within NUM bytes" value-name:"NUM:PATTERN"`
+		NotSurroundedWithinBytes []string `long:"not-surrounded-within-bytes" description:"only show matches not surrounded by PATTERN within NUM bytes" value-name:"NUM:PATTERN"`
 	} `group:"Match Condition options" json:"-"`
 }
 
@@ -212,6 +253,7 @@ func (o *Options) LoadDefaults() {
 	o.ShowFilename = "auto"
 	o.Color = "auto"
 	o.Recursive = true
+	o.PatchContext = 3
 	o.CustomTypes = make(map[string]string)
 
 	o.ColorFunc = func() {
@@ -253,6 +295,9 @@ func (o *Options) LoadDefaults() {
 	o.NoZip = func() {
 		o.Zip = false
 	}
+	o.NoArchives = func() {
+		o.Archives = false
+	}
 	o.Version = func() {
 		fmt.Printf("sift %s (%s/%s)\n", SiftVersion, runtime.GOOS, runtime.GOARCH)
 		fmt.Println("Copyright (C) 2014-2016 Sven Taute")
@@ -345,10 +390,6 @@ func (o *Options) Apply(patterns []string, targets []string) error {
 		o.Replace = `$0`
 	}
 
-	for i := range patterns {
-		patterns[i] = o.preparePattern(patterns[i])
-	}
-
 	runtime.GOMAXPROCS(o.Cores)
 	return nil
 }
@@ -373,7 +414,7 @@ func (o *Options) processTypes() error {
 
 	// parse type definition, e.g. '*.pl,*.pm;\bperl\b'
 	for name, e := range o.CustomTypes {
-		var ft FileType
+		var ft search.FileType
 		s := strings.SplitN(e, ";", 2)
 		if len(s) == 2 && s[1] != "" {
 			re, err := regexp.Compile(s[1])
@@ -440,32 +481,40 @@ func (o *Options) checkFormats() error {
 		}
 	}
 
+	if options.ArchiveDepth < 0 {
+		return fmt.Errorf("the archive depth must be >= 1 (or 0 for the default)")
+	}
+
+	if options.PatchContext < 0 {
+		return fmt.Errorf("the number of patch context lines must be >= 0")
+	}
+
 	if options.Cores < 0 {
 		return fmt.Errorf("the number of cores must be >= 1 (or 0 for 'all')")
 	}
 
 	if options.Blocksize != "" {
-		re := regexp.MustCompile(`^\d+[kKmM]?$`)
-		if !re.MatchString(options.Blocksize) {
+		size, ok := parseSize(options.Blocksize)
+		if !ok {
 			return fmt.Errorf("cannot parse blocksize %q", options.Blocksize)
 		}
-		var blocksize int
-		switch options.Blocksize[len(options.Blocksize)-1:] {
-		case "k", "K":
-			blocksize, _ = strconv.Atoi(options.Blocksize[0 : len(options.Blocksize)-1])
-			InputBlockSize = blocksize * 1024
-		case "m", "M":
-			blocksize, _ = strconv.Atoi(options.Blocksize[0 : len(options.Blocksize)-1])
-			InputBlockSize = blocksize * 1024 * 1024
-		default:
-			blocksize, _ := strconv.Atoi(options.Blocksize)
-			InputBlockSize = blocksize
-		}
+		InputBlockSize = size
 		if InputBlockSize < 256*1024 {
 			return fmt.Errorf("blocksize must be >= 256k")
 		}
 	}
 
+	if options.MaxLineLength != "" {
+		size, ok := parseSize(options.MaxLineLength)
+		if !ok {
+			return fmt.Errorf("cannot parse max-line-length %q", options.MaxLineLength)
+		}
+		InputMaxLineLength = size
+		if InputMaxLineLength < InputBlockSize {
+			return fmt.Errorf("max-line-length must be >= blocksize (%d)", InputBlockSize)
+		}
+	}
+
 	if o.OutputSeparator == "" {
 		o.OutputSeparator = "\n"
 	} else {
@@ -498,39 +547,169 @@ func (o *Options) checkFormats() error {
 	return nil
 }
 
-// preparePattern adjusts a pattern to respect the ignore-case, literal and multiline options
-func (o *Options) preparePattern(pattern string) string {
-	if o.Literal {
-		pattern = regexp.QuoteMeta(pattern)
+// parseSize parses a size in bytes with an optional suffix K or M.
+func parseSize(value string) (int, bool) {
+	re := regexp.MustCompile(`^(\d+)([kKmM]?)$`)
+	m := re.FindStringSubmatch(value)
+	if m == nil {
+		return 0, false
+	}
+	size, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, false
+	}
+	switch m[2] {
+	case "k", "K":
+		size *= 1024
+	case "m", "M":
+		size *= 1024 * 1024
+	}
+	return size, true
+}
+
+// splitList splits a comma-separated option value.
+func splitList(value string) []string {
+	if value == "" {
+		return nil
 	}
-	if o.IgnoreCase {
-		pattern = strings.ToLower(pattern)
+	return strings.Split(value, ",")
+}
+
+// hasUppercase checks whether a pattern contains uppercase letters of any script.
+// Escape sequences like \S or \p{Greek} and the names of capture groups are ignored.
+func hasUppercase(pattern string, literal bool) bool {
+	if literal {
+		return strings.IndexFunc(pattern, unicode.IsUpper) >= 0
 	}
-	if o.WordRegexp {
-		pattern = `\b` + pattern + `\b`
+	quoted := false
+	for i := 0; i < len(pattern); {
+		r, size := utf8.DecodeRuneInString(pattern[i:])
+		switch {
+		case quoted:
+			if strings.HasPrefix(pattern[i:], `\E`) {
+				quoted = false
+				i += 2
+				continue
+			}
+		case r == '\\' && i+1 < len(pattern):
+			switch pattern[i+1] {
+			case 'Q':
+				quoted = true
+				i += 2
+				continue
+			case 'p', 'P':
+				// skip the unicode class name, e.g. \pL or \p{Greek}
+				if i+2 < len(pattern) && pattern[i+2] == '{' {
+					if end := strings.IndexByte(pattern[i:], '}'); end >= 0 {
+						i += end + 1
+						continue
+					}
+				}
+				i += 3
+				continue
+			}
+			// escaped characters like \S or \W are classes, not letters
+			_, size = utf8.DecodeRuneInString(pattern[i+1:])
+			i += 1 + size
+			continue
+		case strings.HasPrefix(pattern[i:], "(?P<"):
+			if end := strings.IndexByte(pattern[i:], '>'); end >= 0 {
+				i += end + 1
+				continue
+			}
+		}
+		if unicode.IsUpper(r) {
+			return true
+		}
+		i += size
 	}
-	pattern = "(?m)" + pattern
-	if o.Multiline {
-		pattern = "(?s)" + pattern
+	return false
+}
+
+// searchOptions returns the options for the search engine.
+func (o *Options) searchOptions(patterns []string) search.Options {
+	opts := search.Options{
+		Patterns:           patterns,
+		Conditions:         global.conditions,
+		ConditionExpr:      global.conditionExpr,
+		IgnoreCase:         o.IgnoreCase,
+		UnicodeCase:        o.UnicodeCase,
+		Literal:            o.Literal,
+		WordRegexp:         o.WordRegexp,
+		Multiline:          o.Multiline,
+		InvertMatch:        o.InvertMatch,
+		BinarySkip:         o.BinarySkip,
+		ContextBefore:      o.ContextBefore,
+		ContextAfter:       o.ContextAfter,
+		Limit:              o.Limit,
+		LineNumbers:        o.ShowLineNumbers || o.JSON || o.SARIF,
+		FileMatchOnly:      (o.FilesWithMatches || o.FilesWithoutMatch || (o.Quiet && !o.Stats)) && !o.Count,
+		BlockSize:          InputBlockSize,
+		MaxLineLength:      InputMaxLineLength,
+		SplitLongLines:     o.SplitLongLines,
+		StreamingThreshold: global.streamingThreshold,
+		Cores:              o.Cores,
+		FileTimeout:        o.FileTimeout,
+		Zip:                o.Zip,
+		Archives:           o.Archives,
+		ArchiveDepth:       o.ArchiveDepth,
+		Mmap:               o.Mmap,
+		Index:              global.index,
+		TargetsOnly:        o.TargetsOnly || o.Explain != "",
+		Recursive:          o.Recursive,
+		FollowSymlinks:     o.FollowSymlinks,
+		Git:                o.Git,
+		GitTracked:         o.GitTracked,
+		GitUntracked:       o.GitUntracked,
+		Revisions:          o.Revisions,
+		AllRevisions:       o.AllRevs,
+		ChangedSince:       o.changedSince(),
+		ChangedLines:       o.ChangedLines,
+		IncludeDirs:        o.IncludeDirs,
+		ExcludeDirs:        o.ExcludeDirs,
+		IncludeFiles:       o.IncludeFiles,
+		ExcludeFiles:       o.ExcludeFiles,
+		IncludeExtensions:  splitList(o.IncludeExtensions),
+		ExcludeExtensions:  splitList(o.ExcludeExtensions),
+		IncludeTypes:       splitList(o.IncludeTypes),
+		ExcludeTypes:       splitList(o.ExcludeTypes),
+		FileTypes:          global.fileTypesMap,
+		IncludePath:        global.includeFilepathRegex,
+		ExcludePath:        global.excludeFilepathRegex,
+		ErrorHandler:       handleSearchError,
+	}
+	if o.DebugSelection {
+		opts.SelectionHandler = logSelection
+	}
+	return opts
+}
+
+// changedSince returns the git revision changed files are compared with,
+// an empty string if all files are searched.
+func (o *Options) changedSince() string {
+	if o.ChangedSince == "" && o.Changed {
+		return "HEAD"
 	}
-	return pattern
+	return o.ChangedSince
 }
 
-// processConditions checks conditions and puts them into global.conditions
+// processConditions checks conditions and puts them into global.conditions.
+// If a query is used, its conditions come first and global.conditionExpr
+// combines them with the conditions given as options.
 func (o *Options) processConditions() error {
-	global.conditions = []Condition{}
-	conditionDirections := []ConditionType{ConditionPreceded, ConditionFollowed, ConditionSurrounded}
+	global.conditions = []search.Condition{}
+	global.conditionExpr = nil
+	if global.query != nil {
+		global.conditions = append(global.conditions, global.query.Conditions...)
+	}
+	conditionDirections := []search.ConditionType{search.ConditionPreceded, search.ConditionFollowed, search.ConditionSurrounded}
 
 	// parse preceded/followed/surrounded conditions without distance limit
 	conditionArgs := [][]string{o.MatchConditions.Preceded, o.MatchConditions.Followed, o.MatchConditions.Surrounded,
 		o.MatchConditions.NotPreceded, o.MatchConditions.NotFollowed, o.MatchConditions.NotSurrounded}
 	for i := range conditionArgs {
 		for _, pattern := range conditionArgs[i] {
-			regex, err := regexp.Compile(o.preparePattern(pattern))
-			if err != nil {
-				return fmt.Errorf("cannot parse condition pattern '%s': %s\n", pattern, err)
-			}
-			global.conditions = append(global.conditions, Condition{regex: regex, conditionType: conditionDirections[i%3], within: -1, negated: i >= 3})
+			global.conditions = append(global.conditions, search.Condition{Pattern: pattern, Type: conditionDirections[i%3], Within: -1, Negated: i >= 3})
 		}
 	}
 
@@ -550,11 +729,28 @@ func (o *Options) processConditions() error {
 			if within < 0 {
 				return fmt.Errorf("distance value must be >= 0\n")
 			}
-			regex, err := regexp.Compile(o.preparePattern(s[1]))
+			global.conditions = append(global.conditions, search.Condition{Pattern: s[1], Type: conditionDirections[i%3], Within: int64(within), Negated: i >= 3})
+		}
+	}
+
+	// parse preceded/followed/surrounded conditions with byte distance limit
+	conditionDirections = []search.ConditionType{search.ConditionPrecededBytes, search.ConditionFollowedBytes, search.ConditionSurroundedBytes}
+	conditionArgs = [][]string{o.MatchConditions.PrecededWithinBytes, o.MatchConditions.FollowedWithinBytes, o.MatchConditions.SurroundedWithinBytes,
+		o.MatchConditions.NotPrecededWithinBytes, o.MatchConditions.NotFollowedWithinBytes, o.MatchConditions.NotSurroundedWithinBytes}
+	for i := range conditionArgs {
+		for _, arg := range conditionArgs[i] {
+			s := strings.SplitN(arg, ":", 2)
+			if len(s) != 2 {
+				return fmt.Errorf("wrong format for condition option '%s'\n", arg)
+			}
+			within, err := strconv.Atoi(s[0])
 			if err != nil {
-				return fmt.Errorf("cannot parse condition pattern '%s': %s", arg, err)
+				return fmt.Errorf("cannot parse condition option '%s': '%s' is not a number\n", arg, s[0])
 			}
-			global.conditions = append(global.conditions, Condition{regex: regex, conditionType: conditionDirections[i%3], within: int64(within), negated: i >= 3})
+			if within < 0 {
+				return fmt.Errorf("distance value must be >= 0\n")
+			}
+			global.conditions = append(global.conditions, search.Condition{Pattern: s[1], Type: conditionDirections[i%3], Within: int64(within), Negated: i >= 3})
 		}
 	}
 
@@ -562,11 +758,27 @@ func (o *Options) processConditions() error {
 	conditionArgs = [][]string{o.FileConditions.FileMatches, o.FileConditions.NotFileMatches}
 	for i := range conditionArgs {
 		for _, pattern := range conditionArgs[i] {
-			regex, err := regexp.Compile(o.preparePattern(pattern))
+			global.conditions = append(global.conditions, search.Condition{Pattern: pattern, Type: search.ConditionFileMatches, Negated: i == 1})
+		}
+	}
+
+	// parse match count conditions
+	conditionArgs = [][]string{o.FileConditions.FileMatchesAtLeast, o.FileConditions.FileMatchesAtMost}
+	conditionCountTypes := []search.ConditionType{search.ConditionFileMatchesAtLeast, search.ConditionFileMatchesAtMost}
+	for i := range conditionArgs {
+		for _, arg := range conditionArgs[i] {
+			s := strings.SplitN(arg, ":", 2)
+			if len(s) != 2 {
+				return fmt.Errorf("wrong format for condition option '%s'\n", arg)
+			}
+			count, err := strconv.Atoi(s[0])
 			if err != nil {
-				return fmt.Errorf("cannot parse condition pattern '%s': %s\n", pattern, err)
+				return fmt.Errorf("cannot parse condition option '%s': '%s' is not a number\n", arg, s[0])
+			}
+			if count < 0 {
+				return fmt.Errorf("match count value must be >= 0\n")
 			}
-			global.conditions = append(global.conditions, Condition{regex: regex, conditionType: ConditionFileMatches, negated: i == 1})
+			global.conditions = append(global.conditions, search.Condition{Pattern: s[1], Type: conditionCountTypes[i], Count: int64(count)})
 		}
 	}
 
@@ -585,11 +797,7 @@ func (o *Options) processConditions() error {
 			if lineno < 1 {
 				return fmt.Errorf("line number value must be > 0\n")
 			}
-			regex, err := regexp.Compile(o.preparePattern(s[1]))
-			if err != nil {
-				return fmt.Errorf("cannot parse condition pattern '%s': %s\n", s[1], err)
-			}
-			global.conditions = append(global.conditions, Condition{regex: regex, conditionType: ConditionLineMatches, lineRangeStart: int64(lineno), negated: i == 1})
+			global.conditions = append(global.conditions, search.Condition{Pattern: s[1], Type: search.ConditionLineMatches, LineRangeStart: int64(lineno), Negated: i == 1})
 		}
 	}
 
@@ -612,12 +820,17 @@ func (o *Options) processConditions() error {
 			if lineStart < 1 || lineEnd < 1 {
 				return fmt.Errorf("line number value must be > 0\n")
 			}
-			regex, err := regexp.Compile(o.preparePattern(s[2]))
-			if err != nil {
-				return fmt.Errorf("cannot parse condition pattern '%s': %s\n", s[2], err)
-			}
-			global.conditions = append(global.conditions, Condition{regex: regex, conditionType: ConditionRangeMatches, lineRangeStart: int64(lineStart), lineRangeEnd: int64(lineEnd), negated: i == 1})
+			global.conditions = append(global.conditions, search.Condition{Pattern: s[2], Type: search.ConditionRangeMatches, LineRangeStart: int64(lineStart), LineRangeEnd: int64(lineEnd), Negated: i == 1})
+		}
+	}
+
+	// all conditions given as options have to be fulfilled in addition to the query
+	if global.query != nil {
+		expr := &search.ConditionExpr{Op: search.ExprAnd, Operands: []*search.ConditionExpr{global.query.Expr}}
+		for i := len(global.query.Conditions); i < len(global.conditions); i++ {
+			expr.Operands = append(expr.Operands, &search.ConditionExpr{Op: search.ExprCondition, Condition: i})
 		}
+		global.conditionExpr = expr
 	}
 
 	return nil
@@ -640,12 +853,6 @@ func (o *Options) checkCompatibility(patterns []string, targets []string) error
 		o.ContextAfter = o.Context
 	}
 
-	if o.InvertMatch && o.Multiline {
-		return errors.New("options 'multiline' and 'invert' cannot be used together")
-	}
-	if netTargetFound && o.InvertMatch {
-		return errors.New("option 'invert' is not supported for network targets")
-	}
 	if o.OutputLimit < 0 {
 		return errors.New("value for option 'output-limit' must be >= 0 (0 = no limit)")
 	}
@@ -654,10 +861,6 @@ func (o *Options) checkCompatibility(patterns []string, targets []string) error
 		return errors.New("context options are not supported when combined with a non-standard 'output-separator'")
 	}
 
-	if (stdinTargetFound || netTargetFound) && (o.ContextBefore > 0 || o.ContextAfter > 0) {
-		return errors.New("context options are not supported when reading from STDIN or network")
-	}
-
 	if (stdinTargetFound || netTargetFound) && o.TargetsOnly {
 		return errors.New("targets option not supported when reading from STDIN or network")
 	}
@@ -670,10 +873,6 @@ func (o *Options) checkCompatibility(patterns []string, targets []string) error
 		return errors.New("illegal combination of list option")
 	}
 
-	if o.Zip && (o.ContextBefore != 0 || o.ContextAfter != 0) {
-		return errors.New("context options cannot be used with zip search enabled")
-	}
-
 	if o.BinarySkip && o.BinaryAsText {
 		return errors.New("options 'binary-skip' and 'binary-text' cannot be used together")
 	}
@@ -682,14 +881,69 @@ func (o *Options) checkCompatibility(patterns []string, targets []string) error
 		return errors.New("options 'err-skip-line-length' and 'err-show-line-length' cannot be used together")
 	}
 
+	if o.SplitLongLines && (o.InvertMatch || o.ContextBefore != 0 || o.ContextAfter != 0) {
+		return errors.New("option 'split-long-lines' cannot be combined with invert or context options")
+	}
+
 	if o.OnlyMatching && o.Replace != "" {
 		return errors.New("options 'only-matching' and 'replace' cannot be used together")
 	}
 
+	if (o.Write || o.Patch) && o.Replace == "" {
+		return errors.New("options 'write' and 'patch' require option 'replace'")
+	}
+	if o.Write && o.Patch {
+		return errors.New("options 'write' and 'patch' cannot be used together")
+	}
+	if (o.DryRun || o.Backup != "") && !o.Write {
+		return errors.New("options 'dry-run' and 'backup' require option 'write'")
+	}
+	if (o.Write || o.Patch) && (o.InvertMatch || o.Count || o.FilesWithMatches || o.FilesWithoutMatch || o.Quiet || o.TargetsOnly ||
+		o.JSON || o.SARIF || o.ContextBefore != 0 || o.ContextAfter != 0) {
+		return errors.New("options 'write' and 'patch' cannot be combined with invert, count, list, quiet, targets, json, sarif or context options")
+	}
+	if (o.Write || o.Patch) && (o.Zip || o.Archives) {
+		return errors.New("options 'write' and 'patch' cannot be used with zip or archive search enabled")
+	}
+	if (o.Write || o.Patch) && (stdinTargetFound || netTargetFound) {
+		return errors.New("options 'write' and 'patch' are not supported when reading from STDIN or network")
+	}
+
 	if o.SmartCase && (len(patterns) > 1 || len(global.conditions) > 0) {
 		return errors.New("the smart case option cannot be used with multiple patterns or conditions")
 	}
 
+	if o.JSON && (o.TargetsOnly || o.GroupByFile || o.OnlyMatching || o.Replace != "") {
+		return errors.New("option 'json' cannot be combined with targets, group, only-matching or replace options")
+	}
+	if o.SARIF && (o.JSON || o.TargetsOnly || o.Count || o.FilesWithMatches || o.FilesWithoutMatch || o.OnlyMatching || o.Replace != "") {
+		return errors.New("option 'sarif' cannot be combined with json, targets, count, list, only-matching or replace options")
+	}
+
+	if (len(o.Revisions) > 0 || o.AllRevs) && (o.Write || o.Patch || o.Index || o.IndexBuild != "" || o.GitTracked || o.GitUntracked) {
+		return errors.New("options 'rev' and 'all-revs' cannot be combined with write, patch, index, index-build, git-tracked or git-untracked options")
+	}
+	if (len(o.Revisions) > 0 || o.AllRevs) && (stdinTargetFound || netTargetFound) {
+		return errors.New("options 'rev' and 'all-revs' are not supported when reading from STDIN or network")
+	}
+
+	if o.changedSince() != "" && (len(o.Revisions) > 0 || o.AllRevs || o.GitTracked || o.GitUntracked) {
+		return errors.New("options 'changed' and 'changed-since' cannot be combined with rev, all-revs, git-tracked or git-untracked options")
+	}
+	if o.changedSince() != "" && (stdinTargetFound || netTargetFound) {
+		return errors.New("options 'changed' and 'changed-since' are not supported when reading from STDIN or network")
+	}
+	if o.ChangedLines && o.changedSince() == "" {
+		return errors.New("option 'changed-lines' requires option 'changed' or 'changed-since'")
+	}
+
+	if o.Index && (o.IndexBuild != "" || o.InvertMatch || o.Zip || o.Archives) {
+		return errors.New("option 'index' cannot be combined with index-build, invert, zip or archive options")
+	}
+	if o.Index && (stdinTargetFound || netTargetFound) {
+		return errors.New("option 'index' is not supported when reading from STDIN or network")
+	}
+
 	if o.ExcludePath != "" && o.ExcludeIPath != "" {
 		return errors.New("options 'exclude-path' and 'exclude-ipath' cannot be used together")
 	}
@@ -764,26 +1018,24 @@ func (o *Options) performAutoDetections(patterns []string, targets []string) {
 		}
 	}
 
-	if len(global.conditions) == 0 {
-		global.streamingAllowed = true
-
-		if len(targets) == 1 {
-			if stdinTargetFound || netTargetFound {
+	if len(targets) == 1 {
+		if stdinTargetFound || netTargetFound {
+			global.streamingThreshold = 0
+			o.GroupByFile = false
+		} else {
+			stat, err := os.Stat(targets[0])
+			if err == nil && stat.Mode()&os.ModeType == 0 {
 				global.streamingThreshold = 0
-				o.GroupByFile = false
-			} else {
-				stat, err := os.Stat(targets[0])
-				if err == nil && stat.Mode()&os.ModeType == 0 {
-					global.streamingThreshold = 0
-				}
 			}
 		}
 	}
 
 	if o.ShowFilename == "auto" {
-		if len(targets) == 1 {
+		// results of revisions are always named "REV:PATH",
+		// results of archives are named by the path of the member
+		if len(targets) == 1 && len(o.Revisions) == 0 && !o.AllRevs {
 			fileinfo, err := os.Stat(targets[0])
-			if err == nil && fileinfo.IsDir() {
+			if err == nil && (fileinfo.IsDir() || o.Archives && search.IsArchive(targets[0])) {
 				o.ShowFilename = "on"
 			} else {
 				o.ShowFilename = "off"
@@ -806,6 +1058,11 @@ func (o *Options) performAutoDetections(patterns []string, targets []string) {
 		}
 	}
 
+	if o.JSON || o.SARIF {
+		o.Color = "off"
+		o.GroupByFile = false
+	}
+
 	if o.GroupByFile {
 		if !terminal.IsTerminal(int(os.Stdout.Fd())) {
 			o.GroupByFile = false
@@ -814,7 +1071,7 @@ func (o *Options) performAutoDetections(patterns []string, targets []string) {
 
 	if !o.IgnoreCase && o.SmartCase {
 		if len(patterns) >= 1 {
-			if m, _ := regexp.MatchString("[A-Z]", patterns[0]); !m {
+			if !hasUppercase(patterns[0], o.Literal) {
 				o.IgnoreCase = true
 			}
 		}
@@ -0,0 +1,259 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/svent/sift/search"
+)
+
+// jsonEvent is a single line of output in JSON mode.
+// Fields not relevant for an event type are omitted.
+type jsonEvent struct {
+	Type           string         `json:"type"`
+	Path           string         `json:"path,omitempty"`
+	LineNumber     int64          `json:"line_number,omitempty"`
+	Column         int64          `json:"column,omitempty"`
+	ByteOffset     *int64         `json:"byte_offset,omitempty"`
+	EndOffset      *int64         `json:"end_offset,omitempty"`
+	LineOffset     *int64         `json:"line_offset,omitempty"`
+	Text           *string        `json:"text,omitempty"`
+	Match          *string        `json:"match,omitempty"`
+	Submatches     []jsonSubmatch `json:"submatches,omitempty"`
+	Pattern        *int           `json:"pattern,omitempty"`
+	PatternText    string         `json:"pattern_text,omitempty"`
+	Matches        *int64         `json:"matches,omitempty"`
+	Binary         bool           `json:"binary,omitempty"`
+	FilesProcessed *int64         `json:"files_processed,omitempty"`
+	FilesMatched   *int64         `json:"files_matched,omitempty"`
+	Elapsed        string         `json:"elapsed,omitempty"`
+}
+
+// jsonSubmatch describes a capture group of a match.
+// Offsets are relative to the beginning of the file.
+type jsonSubmatch struct {
+	Group int    `json:"group"`
+	Name  string `json:"name,omitempty"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+	Text  string `json:"text"`
+}
+
+// writeJSON writes a single event as one line of JSON.
+func writeJSON(event *jsonEvent) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(event); err != nil {
+		errorLogger.Fatalln("cannot convert result to JSON:", err)
+	}
+	if _, err := global.outputFile.Write(buf.Bytes()); err != nil {
+		errorLogger.Fatalln("cannot write to output file:", err)
+	}
+}
+
+// jsonPath returns the target name as printed in JSON events.
+func jsonPath(target string) string {
+	if options.OutputUnixPath {
+		return filepath.ToSlash(target)
+	}
+	return target
+}
+
+// jsonSubmatches returns the capture groups of a match.
+func jsonSubmatches(m *search.Match) []jsonSubmatch {
+	var submatches []jsonSubmatch
+	for _, sm := range global.searcher.Submatches(m) {
+		submatches = append(submatches, jsonSubmatch{
+			Group: sm.Group,
+			Name:  sm.Name,
+			Start: sm.Start,
+			End:   sm.End,
+			Text:  sm.Text,
+		})
+	}
+	return submatches
+}
+
+// jsonPrinter keeps track of the printed context lines of a single result.
+type jsonPrinter struct {
+	path            string
+	lastPrintedLine int64
+	lastMatch       *search.Match
+}
+
+// printContext prints context lines, starting with line number firstLineno.
+// Lines up to the last printed line and from line number limit on are skipped.
+func (p *jsonPrinter) printContext(context string, firstLineno int64, limit int64) {
+	for index, line := range strings.Split(context, "\n") {
+		lineno := firstLineno + int64(index)
+		if lineno <= p.lastPrintedLine || (limit > 0 && lineno >= limit) {
+			continue
+		}
+		text := line
+		writeJSON(&jsonEvent{Type: "context", Path: p.path, LineNumber: lineno, Text: &text})
+		p.lastPrintedLine = lineno
+	}
+}
+
+// printContextAfter prints the context after the previous match up to line number limit.
+func (p *jsonPrinter) printContextAfter(limit int64) {
+	m := p.lastMatch
+	if m == nil || m.ContextAfter == nil {
+		return
+	}
+	lastLine := m.Lineno + int64(strings.Count(m.Line, "\n"))
+	p.printContext(*m.ContextAfter, lastLine+1, limit)
+}
+
+// printMatch prints a match with its context lines.
+func (p *jsonPrinter) printMatch(m search.Match) {
+	p.printContextAfter(m.Lineno)
+	if m.ContextBefore != nil {
+		contextLines := strings.Count(*m.ContextBefore, "\n") + 1
+		p.printContext(*m.ContextBefore, m.Lineno-int64(contextLines), 0)
+	}
+
+	text := m.Line
+	event := &jsonEvent{
+		Type:       "match",
+		Path:       p.path,
+		LineNumber: m.Lineno,
+		Text:       &text,
+	}
+	lineStart := m.LineStart - m.ColumnOffset
+	event.LineOffset = &lineStart
+	if !options.InvertMatch {
+		start, end := m.Start, m.End
+		patternID := m.PatternID
+		match := m.Match
+		event.Column = m.Start - m.LineStart + m.ColumnOffset + 1
+		event.ByteOffset = &start
+		event.EndOffset = &end
+		event.Match = &match
+		event.Submatches = jsonSubmatches(&m)
+		event.Pattern = &patternID
+		if patternID < len(global.matchPatterns) {
+			event.PatternText = global.matchPatterns[patternID]
+		}
+	}
+	writeJSON(event)
+	p.lastPrintedLine = m.Lineno + int64(strings.Count(m.Line, "\n"))
+	p.lastMatch = &m
+}
+
+// printResultJSON is the JSON counterpart of printResult.
+func printResultJSON(result *search.Result) {
+	var matchCount int64
+	path := jsonPath(result.Target)
+	matches := result.Matches
+
+	if options.FilesWithoutMatch {
+		if len(matches) == 0 {
+			writeJSON(&jsonEvent{Type: "end", Path: path, Matches: &matchCount})
+			global.totalResultCount++
+		}
+		return
+	}
+	if options.FilesWithMatches && !options.Count {
+		if len(matches) > 0 {
+			matchCount = 1
+			writeJSON(&jsonEvent{Type: "end", Path: path, Matches: &matchCount, Binary: result.IsBinary})
+			global.totalMatchCount++
+			global.totalResultCount++
+		}
+		return
+	}
+	if options.Count {
+		matchCount = int64(len(matches))
+		if options.Limit != 0 && matchCount > options.Limit {
+			matchCount = options.Limit
+		}
+		if result.Streaming {
+			for matches := range result.MatchChan {
+				matchCount += int64(len(matches))
+				if options.Limit != 0 && matchCount >= options.Limit {
+					matchCount = options.Limit
+					break
+				}
+			}
+		}
+		if matchCount > 0 || !options.FilesWithMatches {
+			writeJSON(&jsonEvent{Type: "end", Path: path, Matches: &matchCount, Binary: result.IsBinary})
+		}
+		global.totalMatchCount += matchCount
+		if matchCount > 0 {
+			global.totalResultCount++
+		}
+		return
+	}
+
+	if len(matches) == 0 {
+		return
+	}
+
+	writeJSON(&jsonEvent{Type: "begin", Path: path, Binary: result.IsBinary})
+	if result.IsBinary && !options.BinarySkip && !options.BinaryAsText {
+		// do not print the content of binary files, only the fact that they match
+		matchCount = 1
+		writeJSON(&jsonEvent{Type: "end", Path: path, Matches: &matchCount, Binary: true})
+		global.totalMatchCount++
+		global.totalResultCount++
+		return
+	}
+
+	p := &jsonPrinter{path: path, lastPrintedLine: -1}
+	for _, match := range matches {
+		p.printMatch(match)
+		matchCount++
+		if options.Limit != 0 && matchCount >= options.Limit {
+			break
+		}
+	}
+	if result.Streaming {
+	matchStreamLoop:
+		for matches := range result.MatchChan {
+			for _, match := range matches {
+				if options.Limit != 0 && matchCount >= options.Limit {
+					break matchStreamLoop
+				}
+				p.printMatch(match)
+				matchCount++
+			}
+		}
+	}
+	p.printContextAfter(0)
+
+	writeJSON(&jsonEvent{Type: "end", Path: path, Matches: &matchCount, Binary: result.IsBinary})
+	global.totalMatchCount += matchCount
+	global.totalResultCount++
+}
+
+// printSummaryJSON prints the final summary event in JSON mode.
+func printSummaryJSON(elapsed time.Duration) {
+	writeJSON(&jsonEvent{
+		Type:           "summary",
+		FilesProcessed: &global.totalTargetCount,
+		FilesMatched:   &global.totalResultCount,
+		Matches:        &global.totalMatchCount,
+		Elapsed:        elapsed.String(),
+	})
+}
@@ -0,0 +1,301 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/svent/sift/search"
+)
+
+const (
+	SarifVersion = "2.1.0"
+	SarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// The following types describe the subset of the SARIF 2.1.0 format
+// (https://docs.oasis-open.org/sarif/sarif/v2.1.0/) written by sift.
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool        sarifTool              `json:"tool"`
+	Invocations []sarifInvocation      `json:"invocations"`
+	ColumnKind  string                 `json:"columnKind"`
+	Results     []sarifResult          `json:"results"`
+	Properties  map[string]interface{} `json:"properties,omitempty"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name,omitempty"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifInvocation struct {
+	CommandLine         string   `json:"commandLine"`
+	Arguments           []string `json:"arguments"`
+	StartTimeUTC        string   `json:"startTimeUtc"`
+	EndTimeUTC          string   `json:"endTimeUtc,omitempty"`
+	ExecutionSuccessful bool     `json:"executionSuccessful"`
+	WorkingDirectory    *struct {
+		URI string `json:"uri"`
+	} `json:"workingDirectory,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+	ContextRegion    *sarifRegion          `json:"contextRegion,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int64         `json:"startLine"`
+	StartColumn int64         `json:"startColumn,omitempty"`
+	EndLine     int64         `json:"endLine,omitempty"`
+	EndColumn   int64         `json:"endColumn,omitempty"`
+	ByteOffset  *int64        `json:"byteOffset,omitempty"`
+	ByteLength  *int64        `json:"byteLength,omitempty"`
+	Snippet     *sarifMessage `json:"snippet,omitempty"`
+}
+
+// sarifReport collects all results of a search run. It is only
+// accessed from handleResult and written at the end of the search.
+var sarifReport struct {
+	run       *sarifRun
+	startTime time.Time
+}
+
+// sarifRuleID returns the rule id for the pattern with the given index.
+func sarifRuleID(patternID int) string {
+	return fmt.Sprintf("sift/pattern/%d", patternID)
+}
+
+// initSarifReport prepares the SARIF run with one rule per pattern.
+func initSarifReport() {
+	sarifReport.startTime = time.Now()
+	run := &sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "sift",
+			Version:        SiftVersion,
+			InformationURI: "https://sift-tool.org",
+			Rules:          []sarifRule{},
+		}},
+		ColumnKind: "unicodeCodePoints",
+		Results:    []sarifResult{},
+	}
+	for i, pattern := range global.matchPatterns {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+			ID:               sarifRuleID(i),
+			Name:             fmt.Sprintf("pattern%d", i),
+			ShortDescription: sarifMessage{Text: pattern},
+		})
+	}
+
+	var conditions []string
+	for _, c := range global.conditions {
+		conditions = append(conditions, c.Pattern)
+	}
+	if len(conditions) > 0 {
+		run.Properties = map[string]interface{}{"conditions": conditions}
+	}
+	sarifReport.run = run
+}
+
+// sarifURI converts a target name to a URI reference.
+func sarifURI(target string) string {
+	path := filepath.ToSlash(target)
+	if filepath.IsAbs(target) {
+		u := url.URL{Scheme: "file", Path: path}
+		if !strings.HasPrefix(path, "/") {
+			u.Path = "/" + path
+		}
+		return u.String()
+	}
+	u := url.URL{Path: path}
+	return u.String()
+}
+
+// sarifPosition returns the line and column of offset pos within the
+// lines of a match, starting at line number lineno.
+func sarifPosition(line string, pos int, lineno int64) (int64, int64) {
+	if pos > len(line) {
+		pos = len(line)
+	}
+	prefix := line[:pos]
+	if i := strings.LastIndex(prefix, "\n"); i >= 0 {
+		lineno += int64(strings.Count(prefix, "\n"))
+		prefix = prefix[i+1:]
+	}
+	return lineno, int64(utf8.RuneCountInString(prefix)) + 1
+}
+
+// sarifAddMatch converts a match to a SARIF result.
+func sarifAddMatch(target string, m *search.Match) {
+	region := &sarifRegion{StartLine: m.Lineno}
+	contextRegion := &sarifRegion{
+		StartLine: m.Lineno,
+		EndLine:   m.Lineno + int64(strings.Count(m.Line, "\n")),
+		Snippet:   &sarifMessage{Text: m.Line},
+	}
+	message := "line does not match any pattern"
+	if !options.InvertMatch {
+		byteOffset, byteLength := m.Start, m.End-m.Start
+		region.StartLine, region.StartColumn = sarifPosition(m.Line, int(m.Start-m.LineStart), m.Lineno)
+		region.EndLine, region.EndColumn = sarifPosition(m.Line, int(m.End-m.LineStart), m.Lineno)
+		if m.ColumnOffset > 0 {
+			// the characters of a long line before the searched window are
+			// unknown, the position is only given by the byte offset
+			region.StartColumn, region.EndColumn = 0, 0
+		}
+		region.ByteOffset = &byteOffset
+		region.ByteLength = &byteLength
+		region.Snippet = &sarifMessage{Text: m.Match}
+		message = fmt.Sprintf("match for pattern '%s'", global.matchPatterns[m.PatternID])
+	} else {
+		region = contextRegion
+		contextRegion = nil
+	}
+	sarifReport.run.Results = append(sarifReport.run.Results, sarifResult{
+		RuleID:    sarifRuleID(m.PatternID),
+		RuleIndex: m.PatternID,
+		Level:     "warning",
+		Message:   sarifMessage{Text: message},
+		Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{URI: sarifURI(target)},
+			Region:           region,
+			ContextRegion:    contextRegion,
+		}}},
+	})
+}
+
+// printResultSarif adds all matches of a result to the SARIF report.
+func printResultSarif(result *search.Result) {
+	var matchCount int64
+	if len(result.Matches) == 0 {
+		return
+	}
+
+	if result.IsBinary && !options.BinarySkip && !options.BinaryAsText {
+		sarifReport.run.Results = append(sarifReport.run.Results, sarifResult{
+			RuleID:    sarifRuleID(result.Matches[0].PatternID),
+			RuleIndex: result.Matches[0].PatternID,
+			Level:     "warning",
+			Message:   sarifMessage{Text: "binary file matches"},
+			Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: sarifURI(result.Target)},
+			}}},
+		})
+		global.totalMatchCount++
+		global.totalResultCount++
+		return
+	}
+
+	for i := range result.Matches {
+		sarifAddMatch(result.Target, &result.Matches[i])
+		matchCount++
+		if options.Limit != 0 && matchCount >= options.Limit {
+			break
+		}
+	}
+	if result.Streaming {
+	matchStreamLoop:
+		for matches := range result.MatchChan {
+			for i := range matches {
+				if options.Limit != 0 && matchCount >= options.Limit {
+					break matchStreamLoop
+				}
+				sarifAddMatch(result.Target, &matches[i])
+				matchCount++
+			}
+		}
+	}
+	global.totalMatchCount += matchCount
+	global.totalResultCount++
+}
+
+// writeSarifReport writes the complete SARIF report to the output file.
+func writeSarifReport() {
+	run := sarifReport.run
+	invocation := sarifInvocation{
+		CommandLine:         strings.Join(os.Args, " "),
+		Arguments:           os.Args[1:],
+		StartTimeUTC:        sarifReport.startTime.UTC().Format(time.RFC3339),
+		EndTimeUTC:          time.Now().UTC().Format(time.RFC3339),
+		ExecutionSuccessful: true,
+	}
+	if wd, err := os.Getwd(); err == nil {
+		invocation.WorkingDirectory = &struct {
+			URI string `json:"uri"`
+		}{URI: sarifURI(wd + string(filepath.Separator))}
+	}
+	run.Invocations = []sarifInvocation{invocation}
+
+	report := sarifLog{Version: SarifVersion, Schema: SarifSchema, Runs: []sarifRun{*run}}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		errorLogger.Fatalln("cannot convert results to SARIF:", err)
+	}
+	if _, err := global.outputFile.Write(buf.Bytes()); err != nil {
+		errorLogger.Fatalln("cannot write to output file:", err)
+	}
+}
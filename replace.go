@@ -0,0 +1,302 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/svent/sift/search"
+)
+
+// collectMatches returns all matches of a result, including streamed matches,
+// respecting the limit option.
+func collectMatches(result *search.Result) search.Matches {
+	matches := result.Matches
+	if result.Streaming {
+		for streamed := range result.MatchChan {
+			matches = append(matches, streamed...)
+		}
+	}
+	if options.Limit != 0 && int64(len(matches)) > options.Limit {
+		matches = matches[:options.Limit]
+	}
+	return matches
+}
+
+// replaceResult applies the replace template to the matches of a result.
+// It returns the original and the modified content of the file and the edits,
+// ok is false if nothing is to be replaced or the file cannot be processed.
+func replaceResult(result *search.Result) (content []byte, modified []byte, edits []search.Edit, ok bool) {
+	matches := collectMatches(result)
+	if len(matches) == 0 || (result.IsBinary && !options.BinaryAsText) {
+		return nil, nil, nil, false
+	}
+
+	content, err := ioutil.ReadFile(result.Target)
+	if err != nil {
+		errorLogger.Printf("cannot read file '%s': %s\n", result.Target, err)
+		return nil, nil, nil, false
+	}
+	// the file must not have been modified since it was searched
+	for _, m := range matches {
+		if m.LineEnd > int64(len(content)) || string(content[m.LineStart:m.LineEnd]) != m.Line {
+			errorLogger.Printf("cannot process file '%s': file changed during search\n", result.Target)
+			return nil, nil, nil, false
+		}
+	}
+
+	edits = global.searcher.Edits(matches, options.Replace)
+	modified = search.ApplyEdits(content, edits)
+	if bytes.Equal(content, modified) {
+		return nil, nil, nil, false
+	}
+	global.totalMatchCount += int64(len(matches))
+	global.totalResultCount++
+	return content, modified, edits, true
+}
+
+// writeResult writes the modified file for a result or, with --dry-run,
+// prints the changes as unified diff.
+func writeResult(result *search.Result) {
+	content, modified, edits, ok := replaceResult(result)
+	if !ok {
+		return
+	}
+	if options.DryRun {
+		writeOutput("%s", targetDiff(result.Target, content, edits))
+		return
+	}
+	if err := replaceFile(result.Target, content, modified, options.Backup); err != nil {
+		errorLogger.Printf("cannot write file '%s': %s\n", result.Target, err)
+	}
+}
+
+// printResultPatch prints the changes for a result as patch that can be
+// applied with 'git apply' or 'patch -p1'.
+func printResultPatch(result *search.Result) {
+	content, _, edits, ok := replaceResult(result)
+	if !ok {
+		return
+	}
+	writeOutput("%s", targetDiff(result.Target, content, edits))
+}
+
+// targetDiff returns the changes made by the edits to a target as unified diff
+// with the file names prefixed by "a/" and "b/" like in git diffs.
+func targetDiff(target string, content []byte, edits []search.Edit) string {
+	name := filepath.ToSlash(filepath.Clean(target))
+	return unifiedDiff("a/"+name, "b/"+name, content, edits, options.PatchContext)
+}
+
+// replaceFile atomically replaces the content of a file by writing to a temporary
+// file in the same directory and renaming it. If backupSuffix is set, the original
+// content is saved to a file with that suffix first.
+func replaceFile(name string, original []byte, modified []byte, backupSuffix string) error {
+	// replace the file a symlink points to, not the symlink itself
+	name, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return err
+	}
+	fileinfo, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if !fileinfo.Mode().IsRegular() {
+		return errors.New("not a regular file")
+	}
+	if backupSuffix != "" {
+		if err := ioutil.WriteFile(name+backupSuffix, original, fileinfo.Mode().Perm()); err != nil {
+			return fmt.Errorf("cannot write backup: %s", err)
+		}
+	}
+
+	tmpfile, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".sift")
+	if err != nil {
+		return err
+	}
+	_, err = tmpfile.Write(modified)
+	if err == nil {
+		err = tmpfile.Chmod(fileinfo.Mode().Perm())
+	}
+	if err == nil {
+		err = tmpfile.Sync()
+	}
+	if closeErr := tmpfile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpfile.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmpfile.Name())
+	}
+	return err
+}
+
+// diffBlock is a range of lines changed by edits.
+type diffBlock struct {
+	// first and last line (0-based) of the original content
+	first int
+	last  int
+	// the original and the modified lines, including the newlines
+	oldLines []string
+	newLines []string
+}
+
+// unifiedDiff returns the changes made by the edits to content in the unified diff format.
+func unifiedDiff(oldName string, newName string, content []byte, edits []search.Edit, contextLines int) string {
+	var lineStarts []int
+	for pos := 0; pos < len(content); {
+		lineStarts = append(lineStarts, pos)
+		next := bytes.IndexByte(content[pos:], '\n')
+		if next < 0 {
+			break
+		}
+		pos += next + 1
+	}
+	lineCount := len(lineStarts)
+	lineOf := func(pos int64) int {
+		return sort.Search(lineCount, func(i int) bool { return int64(lineStarts[i]) > pos }) - 1
+	}
+	lineOffset := func(line int) int {
+		if line >= lineCount {
+			return len(content)
+		}
+		return lineStarts[line]
+	}
+
+	// group the edits by the lines they change
+	var blocks []diffBlock
+	for i := 0; i < len(edits); {
+		first := lineOf(edits[i].Start)
+		if edits[i].Start == int64(len(content)) {
+			first = lineCount
+		}
+		last := lineOf(edits[i].End)
+		j := i + 1
+		// edits on adjacent lines are combined, like changed lines in a diff
+		for ; j < len(edits) && lineOf(edits[j].Start) <= last+1; j++ {
+			if l := lineOf(edits[j].End); l > last {
+				last = l
+			}
+		}
+		start, end := lineOffset(first), lineOffset(last+1)
+		blockEdits := make([]search.Edit, j-i)
+		for k, e := range edits[i:j] {
+			blockEdits[k] = search.Edit{Start: e.Start - int64(start), End: e.End - int64(start), Text: e.Text}
+		}
+		oldText := content[start:end]
+		newText := search.ApplyEdits(oldText, blockEdits)
+		if !bytes.Equal(oldText, newText) {
+			blocks = append(blocks, diffBlock{
+				first:    first,
+				last:     last,
+				oldLines: splitLines(string(oldText)),
+				newLines: splitLines(string(newText)),
+			})
+		}
+		i = j
+	}
+	if len(blocks) == 0 {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldName, newName)
+	// difference between the line numbers of the modified and the original content
+	delta := 0
+	for i := 0; i < len(blocks); {
+		// blocks with overlapping context are combined into a single hunk
+		j := i + 1
+		for j < len(blocks) && blocks[j].first-blocks[j-1].last-1 <= 2*contextLines {
+			j++
+		}
+		hunkStart := blocks[i].first - contextLines
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		hunkEnd := blocks[j-1].last + contextLines
+		if hunkEnd > lineCount-1 {
+			hunkEnd = lineCount - 1
+		}
+
+		var hunk bytes.Buffer
+		oldCount := hunkEnd - hunkStart + 1
+		newCount := oldCount
+		line := hunkStart
+		for _, b := range blocks[i:j] {
+			for ; line < b.first; line++ {
+				writeDiffLine(&hunk, ' ', string(content[lineOffset(line):lineOffset(line+1)]))
+			}
+			for _, l := range b.oldLines {
+				writeDiffLine(&hunk, '-', l)
+			}
+			for _, l := range b.newLines {
+				writeDiffLine(&hunk, '+', l)
+			}
+			newCount += len(b.newLines) - len(b.oldLines)
+			line = b.last + 1
+		}
+		for ; line <= hunkEnd; line++ {
+			writeDiffLine(&hunk, ' ', string(content[lineOffset(line):lineOffset(line+1)]))
+		}
+
+		// empty ranges start at the line before
+		oldStart, newStart := hunkStart+1, hunkStart+1+delta
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		buf.Write(hunk.Bytes())
+		delta += newCount - oldCount
+		i = j
+	}
+	return buf.String()
+}
+
+// writeDiffLine writes a line of a hunk, marking a missing newline at the end of the file.
+func writeDiffLine(buf *bytes.Buffer, prefix byte, line string) {
+	buf.WriteByte(prefix)
+	buf.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		buf.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// splitLines splits text into lines, keeping the newlines.
+func splitLines(text string) []string {
+	var lines []string
+	for len(text) > 0 {
+		end := strings.IndexByte(text, '\n')
+		if end < 0 {
+			lines = append(lines, text)
+			break
+		}
+		lines = append(lines, text[:end+1])
+		text = text[end+1:]
+	}
+	return lines
+}
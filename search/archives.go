@@ -0,0 +1,205 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ArchiveSeparator separates the name of an archive and the path of a member
+// in the target names of archive members, e.g. "logs.tar.gz!var/log/syslog".
+const ArchiveSeparator = "!"
+
+type archiveKind int
+
+const (
+	archiveNone archiveKind = iota
+	archiveZip
+	archiveTar
+)
+
+var (
+	zipExtensions = []string{".zip", ".jar", ".war", ".ear"}
+	// tarExtensions are the short forms of compressed tar archives
+	tarExtensions = []string{".tar", ".tgz", ".tbz", ".tbz2", ".txz", ".tzst"}
+)
+
+// IsArchive checks whether a file is searched as archive with Options.Archives,
+// based on the file name.
+func IsArchive(name string) bool {
+	return archiveKindOf(name) != archiveNone
+}
+
+// archiveKindOf returns the kind of archive based on the file name.
+func archiveKindOf(name string) archiveKind {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range zipExtensions {
+		if ext == e {
+			return archiveZip
+		}
+	}
+	for _, e := range tarExtensions {
+		if ext == e {
+			return archiveTar
+		}
+	}
+	// compressed tar archives, e.g. .tar.gz or .tar.xz
+	if decompressorByExtension(name) != nil {
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		if strings.ToLower(filepath.Ext(base)) == ".tar" {
+			return archiveTar
+		}
+	}
+	return archiveNone
+}
+
+// processArchive searches all members of an archive. The target name of a member
+// is the name of the archive and the path of the member, separated by ArchiveSeparator.
+// depth is the nesting depth of the archive, starting at 1.
+func (r *searchRun) processArchive(ctx context.Context, reader io.Reader, name string, depth int,
+	dataBuffer []byte, testBuffer []byte) error {
+	switch archiveKindOf(name) {
+	case archiveZip:
+		var readerAt io.ReaderAt
+		var size int64
+		if f, ok := reader.(*os.File); ok {
+			fi, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			readerAt, size = f, fi.Size()
+		} else {
+			// nested archive, zip needs random access
+			data, err := ioutil.ReadAll(io.LimitReader(reader, MaxNestedZipSize+1))
+			if err != nil {
+				return err
+			}
+			if len(data) > MaxNestedZipSize {
+				return fmt.Errorf("nested zip archive exceeds %d bytes", MaxNestedZipSize)
+			}
+			readerAt, size = bytes.NewReader(data), int64(len(data))
+		}
+		zr, err := zip.NewReader(readerAt, size)
+		if err != nil {
+			return err
+		}
+		for _, f := range zr.File {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !f.FileInfo().Mode().IsRegular() {
+				continue
+			}
+			target := name + ArchiveSeparator + f.Name
+			if !r.includeFile(path.Base(f.Name), target, false) {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				r.reportTargetError("cannot open archive member", target, err)
+				continue
+			}
+			err = r.processArchiveMember(ctx, rc, target, depth, dataBuffer, testBuffer)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	case archiveTar:
+		reader, closer, err := decompressReader(reader, name)
+		if err != nil {
+			return err
+		}
+		if closer != nil {
+			defer closer.Close()
+		}
+		tr := tar.NewReader(reader)
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if !hdr.FileInfo().Mode().IsRegular() {
+				continue
+			}
+			target := name + ArchiveSeparator + strings.TrimPrefix(hdr.Name, "./")
+			if !r.includeFile(path.Base(hdr.Name), target, false) {
+				continue
+			}
+			if err := r.processArchiveMember(ctx, tr, target, depth, dataBuffer, testBuffer); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// processArchiveMember searches a single archive member, recursing into nested
+// archives up to the configured depth. Errors are only returned if the search
+// of the archive cannot continue.
+func (r *searchRun) processArchiveMember(ctx context.Context, reader io.Reader, target string, depth int,
+	dataBuffer []byte, testBuffer []byte) error {
+	if depth < r.archiveDepth && archiveKindOf(target) != archiveNone {
+		err := r.processArchive(ctx, reader, target, depth+1, dataBuffer, testBuffer)
+		if err != nil && ctx.Err() == nil {
+			r.reportTargetError("cannot process archive", target, err)
+			return nil
+		}
+		return err
+	}
+
+	if r.opts.TargetsOnly {
+		r.resultsChan <- &Result{Target: target}
+		return nil
+	}
+
+	if r.opts.Zip {
+		decompressed, closer, err := decompressReader(reader, target)
+		if err != nil {
+			r.reportTargetError("error decompressing file", target, err)
+			return nil
+		}
+		if closer != nil {
+			defer closer.Close()
+		}
+		reader = decompressed
+	}
+
+	err := r.processReader(ctx, reader, dataBuffer, testBuffer, target)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		r.reportTargetError("cannot process data from file", target, err)
+	}
+	return nil
+}
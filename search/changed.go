@@ -0,0 +1,194 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/svent/sift/git"
+)
+
+// processChangedTarget sends the files of target on filesChan whose content
+// in the work tree differs from the revision ChangedSince. Only files in the
+// git index are considered, i.e. tracked files and files added to the index.
+// A file given as target is not checked against the file options.
+func (r *searchRun) processChangedTarget(target string, isDir bool) {
+	defer r.recurseWaitGroup.Done()
+	repo, err := git.Find(target)
+	if err != nil {
+		r.reportError("cannot open git repository", target, err)
+		return
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		r.reportError("cannot open git repository", target, err)
+		return
+	}
+	prefix, err := filepath.Rel(repo.Root, absTarget)
+	if err != nil {
+		r.reportError("cannot open git repository", target, err)
+		return
+	}
+	prefix = filepath.ToSlash(prefix)
+	if isDir {
+		prefix += "/"
+		if prefix == "./" {
+			prefix = ""
+		}
+	}
+
+	// in a repository without commits, all files are new
+	base := map[string]git.TreeEntry{}
+	rev, err := repo.Resolve(r.opts.ChangedSince)
+	if err == git.ErrUnknownRevision && r.opts.ChangedSince == "HEAD" {
+		err = nil
+	} else if err == nil {
+		var tree git.Hash
+		if tree, err = repo.Tree(rev); err == nil {
+			base, err = repo.Files(tree)
+		}
+	}
+	if err != nil {
+		r.reportError("cannot read revision", r.opts.ChangedSince, err)
+		return
+	}
+	entries, err := repo.Index()
+	if err != nil {
+		r.reportError("cannot read git index", target, err)
+		return
+	}
+
+	dirs := map[string]bool{filepath.Clean(target): true}
+	for i, e := range entries {
+		if r.ctx.Err() != nil {
+			return
+		}
+		// files with merge conflicts have an entry per stage
+		if i > 0 && entries[i-1].Path == e.Path {
+			continue
+		}
+		if isDir && !strings.HasPrefix(e.Path, prefix) || !isDir && e.Path != prefix {
+			continue
+		}
+		if e.SkipWorktree || e.Mode&git.ModeTypeMask != git.ModeRegular {
+			continue
+		}
+		baseEntry, tracked := base[e.Path]
+		if tracked && baseEntry.Mode&git.ModeTypeMask != git.ModeRegular {
+			tracked = false
+		}
+		// files missing in the revision are always changed
+		if tracked {
+			hash, err := repo.WorktreeHash(e)
+			if err != nil && !os.IsNotExist(err) {
+				r.reportError("cannot compare file with revision", e.Path, err)
+			}
+			if err != nil || hash == baseEntry.Hash {
+				continue
+			}
+		}
+
+		path := filepath.Clean(target)
+		if isDir {
+			path = filepath.Join(target, filepath.FromSlash(e.Path[len(prefix):]))
+			dirname := filepath.Dir(path)
+			if !r.selectTrackedDir(dirname, dirs) {
+				continue
+			}
+			fi, err := os.Lstat(path)
+			if err != nil {
+				// deleted in the work tree
+				continue
+			}
+			selected, _, decision := r.selectEntry(dirname, fi, nil)
+			if r.opts.SelectionHandler != nil {
+				r.opts.SelectionHandler(r.selection(path, fi, selected != "", decision, nil))
+			}
+			if selected == "" {
+				continue
+			}
+			path = selected
+		} else if _, err := os.Lstat(path); err != nil {
+			continue
+		}
+
+		if r.opts.ChangedLines && tracked {
+			ranges, err := r.changedLines(repo, baseEntry.Hash, path)
+			if err != nil {
+				r.reportError("cannot compare file with revision", path, err)
+				continue
+			}
+			r.changedMutex.Lock()
+			r.changedRanges[path] = ranges
+			r.changedMutex.Unlock()
+		}
+		r.sendFile(path)
+	}
+}
+
+// changedLines returns the ranges of lines of a file that were added or
+// modified since the version of the file stored as blob.
+func (r *searchRun) changedLines(repo *git.Repository, blob git.Hash, path string) ([]git.LineRange, error) {
+	typ, old, err := repo.ReadObject(blob)
+	if err != nil {
+		return nil, err
+	}
+	if typ != git.ObjectBlob {
+		return nil, fmt.Errorf("object %s is a %s", blob, typ)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return git.ChangedLines(old, data), nil
+}
+
+// lineRanges returns the ranges of changed lines matches are restricted to
+// for a target, nil if matches are not restricted.
+func (r *searchRun) lineRanges(target string) []git.LineRange {
+	if !r.opts.ChangedLines {
+		return nil
+	}
+	r.changedMutex.RLock()
+	defer r.changedMutex.RUnlock()
+	ranges, ok := r.changedRanges[target]
+	if ok && ranges == nil {
+		// no line was added or modified
+		return []git.LineRange{}
+	}
+	return ranges
+}
+
+// matchesOnLines returns the matches touching any of the line ranges.
+// Line numbers must have been counted for the matches.
+func matchesOnLines(matches Matches, ranges []git.LineRange) Matches {
+	var result Matches
+	for _, m := range matches {
+		first := m.Lineno
+		last := first + int64(strings.Count(strings.TrimSuffix(m.Line, "\n"), "\n"))
+		for _, lr := range ranges {
+			if lr.Start <= last && first <= lr.End {
+				result = append(result, m)
+				break
+			}
+		}
+	}
+	return result
+}
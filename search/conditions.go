@@ -0,0 +1,348 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"errors"
+	"fmt"
+)
+
+// conditionState is the state of a condition for a match. A condition is
+// undecided as long as the input needed to decide it has not been read.
+type conditionState int
+
+const (
+	conditionUndecided conditionState = iota
+	conditionFalse
+	conditionTrue
+)
+
+// newConditionState returns the state for a condition that is fulfilled or
+// decided to be not fulfilled.
+func newConditionState(fulfilled bool, decided bool) conditionState {
+	switch {
+	case fulfilled:
+		return conditionTrue
+	case decided:
+		return conditionFalse
+	}
+	return conditionUndecided
+}
+
+// negate returns the state of the negated condition.
+func (st conditionState) negate() conditionState {
+	switch st {
+	case conditionTrue:
+		return conditionFalse
+	case conditionFalse:
+		return conditionTrue
+	}
+	return conditionUndecided
+}
+
+// conditionEvaluator applies the conditions to the matches of a target while
+// the input is read. Matches are held until the conditions are decided for
+// them, so the evaluation works on streamed input. Condition matches are only
+// kept as long as they can affect matches that are not decided yet, for the
+// other conditions only the number of matches is kept.
+type conditionEvaluator struct {
+	s *Searcher
+	// matches waiting for the conditions to be decided
+	held Matches
+	// condition matches for each preceded/followed/surrounded condition
+	conditionMatches []Matches
+	// number of matches for each condition
+	counts []int64
+	// whether line and range conditions are matched within their lines
+	found []bool
+	// the number of lines and bytes read completely
+	lines  int64
+	offset int64
+	isEOF  bool
+	status []conditionState
+}
+
+func newConditionEvaluator(s *Searcher) *conditionEvaluator {
+	return &conditionEvaluator{
+		s:                s,
+		conditionMatches: make([]Matches, len(s.conditions)),
+		counts:           make([]int64, len(s.conditions)),
+		found:            make([]bool, len(s.conditions)),
+		status:           make([]conditionState, len(s.conditions)),
+	}
+}
+
+// add adds the matches and the condition matches of a processed block.
+// lines and offset are the number of lines and bytes read completely.
+func (e *conditionEvaluator) add(matches Matches, conditionMatches Matches, lines int64, offset int64, isEOF bool) {
+	e.held = append(e.held, matches...)
+	for _, conditionMatch := range conditionMatches {
+		id := conditionMatch.conditionID
+		c := &e.s.conditions[id]
+		e.counts[id]++
+		switch c.Type {
+		case ConditionFileMatches, ConditionFileMatchesAtLeast, ConditionFileMatchesAtMost:
+		case ConditionLineMatches:
+			if conditionMatch.Lineno == c.LineRangeStart {
+				e.found[id] = true
+			}
+		case ConditionRangeMatches:
+			if conditionMatch.Lineno >= c.LineRangeStart && conditionMatch.Lineno <= c.LineRangeEnd {
+				e.found[id] = true
+			}
+		default:
+			// without distance limit, the first condition match fulfills the condition
+			// for all following matches, unless it depends on the match
+			if c.Within < 0 && c.Type != ConditionFollowed && len(c.groupRefs) == 0 && len(e.conditionMatches[id]) > 0 {
+				continue
+			}
+			e.conditionMatches[id] = append(e.conditionMatches[id], conditionMatch)
+		}
+	}
+	e.lines, e.offset, e.isEOF = lines, offset, isEOF
+}
+
+// release returns the matches fulfilling the conditions, preserving the order
+// of the matches. Matches not fulfilling the conditions are discarded.
+// pending is the first match not added yet, if any.
+func (e *conditionEvaluator) release(pending *Match) Matches {
+	var released Matches
+	n := 0
+	for ; n < len(e.held); n++ {
+		state := e.evaluate(&e.held[n])
+		if state == conditionUndecided {
+			break
+		}
+		if state == conditionTrue {
+			released = append(released, e.held[n])
+		}
+	}
+	e.held = append(e.held[:0], e.held[n:]...)
+	e.prune(pending)
+	return released
+}
+
+// prune removes the condition matches that cannot affect the held matches,
+// pending and the following matches.
+func (e *conditionEvaluator) prune(pending *Match) {
+	line, offset := e.lines+1, e.offset
+	for _, m := range []*Match{pending, e.firstHeld()} {
+		if m != nil && m.Lineno < line {
+			line = m.Lineno
+		}
+		if m != nil && m.Start < offset {
+			offset = m.Start
+		}
+	}
+	for id, conditionMatches := range e.conditionMatches {
+		c := &e.s.conditions[id]
+		n := 0
+		for n < len(conditionMatches) && !c.affects(&conditionMatches[n], line, offset) {
+			n++
+		}
+		if n > 0 {
+			e.conditionMatches[id] = append(conditionMatches[:0], conditionMatches[n:]...)
+		}
+	}
+}
+
+func (e *conditionEvaluator) firstHeld() *Match {
+	if len(e.held) == 0 {
+		return nil
+	}
+	return &e.held[0]
+}
+
+// evaluate returns whether a match fulfills the conditions.
+func (e *conditionEvaluator) evaluate(match *Match) conditionState {
+	groups := &matchGroups{match: match}
+	for id := range e.s.conditions {
+		e.status[id] = e.conditionState(id, match, groups)
+	}
+	if e.s.conditionExpr != nil {
+		return e.s.conditionExpr.eval(e.status)
+	}
+	// all conditions have to be fulfilled
+	state := conditionTrue
+	for _, st := range e.status {
+		if st == conditionFalse {
+			return conditionFalse
+		}
+		if st == conditionUndecided {
+			state = conditionUndecided
+		}
+	}
+	return state
+}
+
+// conditionState returns the state of a condition for a match.
+func (e *conditionEvaluator) conditionState(id int, match *Match, groups *matchGroups) conditionState {
+	c := &e.s.conditions[id]
+	var state conditionState
+	switch c.Type {
+	case ConditionFileMatches:
+		state = newConditionState(e.counts[id] > 0, e.isEOF)
+	case ConditionFileMatchesAtLeast:
+		state = newConditionState(e.counts[id] >= c.Count, e.isEOF)
+	case ConditionFileMatchesAtMost:
+		state = newConditionState(e.counts[id] > c.Count, e.isEOF).negate()
+	case ConditionLineMatches:
+		state = newConditionState(e.found[id], e.isEOF || e.lines >= c.LineRangeStart)
+	case ConditionRangeMatches:
+		state = newConditionState(e.found[id], e.isEOF || e.lines >= c.LineRangeEnd)
+	default:
+		fulfilled := false
+		for i := range e.conditionMatches[id] {
+			if e.s.conditionFulfilled(&e.conditionMatches[id][i], groups) {
+				fulfilled = true
+				break
+			}
+		}
+		state = newConditionState(fulfilled, e.isEOF || e.complete(c, match))
+	}
+	if c.Negated {
+		return state.negate()
+	}
+	return state
+}
+
+// complete checks whether all condition matches that can fulfill a
+// preceded/followed/surrounded condition for a match have been found.
+func (e *conditionEvaluator) complete(c *condition, match *Match) bool {
+	switch c.Type {
+	case ConditionFollowed, ConditionSurrounded:
+		return c.Within >= 0 && e.lines >= match.Lineno+c.Within
+	case ConditionFollowedBytes, ConditionSurroundedBytes:
+		return e.offset > match.End+c.Within
+	}
+	return true
+}
+
+// affects checks whether a condition match can affect matches starting at or
+// after the given line and offset.
+func (c *condition) affects(conditionMatch *Match, line int64, offset int64) bool {
+	switch c.Type {
+	case ConditionPreceded, ConditionSurrounded:
+		return c.Within < 0 || line <= conditionMatch.Lineno+c.Within
+	case ConditionFollowed:
+		return line <= conditionMatch.Lineno
+	case ConditionPrecededBytes, ConditionSurroundedBytes:
+		return offset <= conditionMatch.End+c.Within
+	case ConditionFollowedBytes:
+		return offset <= conditionMatch.Start
+	}
+	return false
+}
+
+// fulfilledBy checks whether a preceded/followed/surrounded condition is fulfilled
+// for a match by a match of the condition pattern.
+func (c *condition) fulfilledBy(conditionMatch *Match, match *Match) bool {
+	var distance int64
+	switch c.Type {
+	case ConditionPrecededBytes:
+		distance = match.Start - conditionMatch.End
+	case ConditionFollowedBytes:
+		distance = conditionMatch.Start - match.End
+	case ConditionSurroundedBytes:
+		switch {
+		case conditionMatch.End <= match.Start:
+			distance = match.Start - conditionMatch.End
+		case conditionMatch.Start >= match.End:
+			distance = conditionMatch.Start - match.End
+		}
+	case ConditionPreceded:
+		distance = match.Lineno - conditionMatch.Lineno
+		if distance == 0 {
+			return conditionMatch.Start < match.Start
+		}
+	case ConditionFollowed:
+		distance = conditionMatch.Lineno - match.Lineno
+		if distance == 0 {
+			return conditionMatch.Start > match.Start
+		}
+	case ConditionSurrounded:
+		distance = match.Lineno - conditionMatch.Lineno
+		if distance < 0 {
+			distance = -distance
+		}
+		if distance == 0 {
+			return true
+		}
+	default:
+		return false
+	}
+	return distance >= 0 && (c.Within == -1 || distance <= c.Within)
+}
+
+// eval evaluates the expression for the given states of the conditions.
+func (e *ConditionExpr) eval(status []conditionState) conditionState {
+	switch e.Op {
+	case ExprCondition:
+		return status[e.Condition]
+	case ExprAnd:
+		state := conditionTrue
+		for _, operand := range e.Operands {
+			switch operand.eval(status) {
+			case conditionFalse:
+				return conditionFalse
+			case conditionUndecided:
+				state = conditionUndecided
+			}
+		}
+		return state
+	case ExprOr:
+		state := conditionFalse
+		for _, operand := range e.Operands {
+			switch operand.eval(status) {
+			case conditionTrue:
+				return conditionTrue
+			case conditionUndecided:
+				state = conditionUndecided
+			}
+		}
+		return state
+	case ExprNot:
+		return e.Operands[0].eval(status).negate()
+	}
+	return conditionFalse
+}
+
+// validate checks the structure of the expression and the condition indexes.
+func (e *ConditionExpr) validate(conditionCount int) error {
+	switch e.Op {
+	case ExprCondition:
+		if e.Condition < 0 || e.Condition >= conditionCount {
+			return fmt.Errorf("invalid condition index %d in condition expression", e.Condition)
+		}
+		return nil
+	case ExprAnd, ExprOr:
+		if len(e.Operands) == 0 {
+			return errors.New("missing operands in condition expression")
+		}
+	case ExprNot:
+		if len(e.Operands) != 1 {
+			return errors.New("negation in condition expression needs exactly one operand")
+		}
+	default:
+		return fmt.Errorf("invalid operator %d in condition expression", e.Op)
+	}
+	for _, operand := range e.Operands {
+		if err := operand.validate(conditionCount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// searchLines searches data and returns the line numbers of the matches.
+func searchLines(t *testing.T, opts Options, data string) []int64 {
+	opts.LineNumbers = true
+	s, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	var lines []int64
+	collect := func(matches Matches) {
+		for _, m := range matches {
+			lines = append(lines, m.Lineno)
+		}
+	}
+	err = s.SearchBytes(context.Background(), []byte(data), "test", func(result *Result) {
+		collect(result.Matches)
+		if result.Streaming {
+			for matches := range result.MatchChan {
+				collect(matches)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("SearchBytes: %s", err)
+	}
+	return lines
+}
+
+func TestConditions(t *testing.T) {
+	data := "" +
+		"start\n" + // 1
+		"match a\n" + // 2
+		"filler\n" + // 3
+		"match b\n" + // 4
+		"end\n" + // 5
+		"filler\n" + // 6
+		"filler\n" + // 7
+		"match c end\n" + // 8
+		"match d\n" // 9
+	tests := []struct {
+		name       string
+		conditions []Condition
+		expr       *ConditionExpr
+		want       []int64
+	}{
+		{"none", nil, nil, []int64{2, 4, 8, 9}},
+		{"preceded", []Condition{{Pattern: "start", Type: ConditionPreceded, Within: -1}}, nil, []int64{2, 4, 8, 9}},
+		{"preceded within", []Condition{{Pattern: "start", Type: ConditionPreceded, Within: 1}}, nil, []int64{2}},
+		{"preceded on the same line", []Condition{{Pattern: "end", Type: ConditionPreceded, Within: 0}}, nil, nil},
+		{"followed within", []Condition{{Pattern: "end", Type: ConditionFollowed, Within: 1}}, nil, []int64{4, 8}},
+		{"followed on the same line", []Condition{{Pattern: "end", Type: ConditionFollowed, Within: 0}}, nil, []int64{8}},
+		{"followed", []Condition{{Pattern: "end", Type: ConditionFollowed, Within: -1}}, nil, []int64{2, 4, 8}},
+		{"surrounded", []Condition{{Pattern: "end", Type: ConditionSurrounded, Within: 1}}, nil, []int64{4, 8, 9}},
+		{"not preceded", []Condition{{Pattern: "filler", Type: ConditionPreceded, Within: 1, Negated: true}}, nil, []int64{2, 9}},
+		{"preceded bytes", []Condition{{Pattern: "filler", Type: ConditionPrecededBytes, Within: 1}}, nil, []int64{4, 8}},
+		{"followed bytes", []Condition{{Pattern: "end", Type: ConditionFollowedBytes, Within: 3}}, nil, []int64{4, 8}},
+		{"followed bytes too far", []Condition{{Pattern: "end", Type: ConditionFollowedBytes, Within: 2}}, nil, nil},
+		{"file matches", []Condition{{Pattern: "^end$", Type: ConditionFileMatches}}, nil, []int64{2, 4, 8, 9}},
+		{"file does not match", []Condition{{Pattern: "^none$", Type: ConditionFileMatches}}, nil, nil},
+		{"file matches negated", []Condition{{Pattern: "^none$", Type: ConditionFileMatches, Negated: true}}, nil, []int64{2, 4, 8, 9}},
+		{"file matches at least", []Condition{{Pattern: "filler", Type: ConditionFileMatchesAtLeast, Count: 3}}, nil, []int64{2, 4, 8, 9}},
+		{"file matches at least too few", []Condition{{Pattern: "filler", Type: ConditionFileMatchesAtLeast, Count: 4}}, nil, nil},
+		{"file matches at most", []Condition{{Pattern: "filler", Type: ConditionFileMatchesAtMost, Count: 2}}, nil, nil},
+		{"line matches", []Condition{{Pattern: "end", Type: ConditionLineMatches, LineRangeStart: 5, LineRangeEnd: 5}}, nil, []int64{2, 4, 8, 9}},
+		{"line does not match", []Condition{{Pattern: "end", Type: ConditionLineMatches, LineRangeStart: 6, LineRangeEnd: 6}}, nil, nil},
+		{"range matches", []Condition{{Pattern: "end", Type: ConditionRangeMatches, LineRangeStart: 6, LineRangeEnd: 8}}, nil, []int64{2, 4, 8, 9}},
+		{"all conditions", []Condition{
+			{Pattern: "start", Type: ConditionPreceded, Within: 3},
+			{Pattern: "end", Type: ConditionFollowed, Within: 1},
+		}, nil, []int64{4}},
+		{"or", []Condition{
+			{Pattern: "start", Type: ConditionPreceded, Within: 1},
+			{Pattern: "end", Type: ConditionFollowed, Within: 0},
+		}, &ConditionExpr{Op: ExprOr, Operands: []*ConditionExpr{
+			{Op: ExprCondition, Condition: 0},
+			{Op: ExprCondition, Condition: 1},
+		}}, []int64{2, 8}},
+		{"not", []Condition{
+			{Pattern: "start", Type: ConditionPreceded, Within: 1},
+		}, &ConditionExpr{Op: ExprNot, Operands: []*ConditionExpr{
+			{Op: ExprCondition, Condition: 0},
+		}}, []int64{4, 8, 9}},
+	}
+	for _, test := range tests {
+		// small blocks make conditions span several blocks
+		for _, blockSize := range []int{0, 16} {
+			opts := Options{
+				Patterns:      []string{"match"},
+				Conditions:    test.conditions,
+				ConditionExpr: test.expr,
+				BlockSize:     blockSize,
+			}
+			got := searchLines(t, opts, data)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s (block size %d): matches on lines %v, want %v", test.name, blockSize, got, test.want)
+			}
+		}
+	}
+}
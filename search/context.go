@@ -0,0 +1,203 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"bytes"
+	"strings"
+)
+
+// contextBuffer provides the context lines of matches while reading the input
+// block by block, so context works on any io.Reader. It keeps the last lines of
+// the processed blocks for the context before matches and holds back matches
+// until the lines for their context after have been read.
+// For memory-mapped input, the context lines are taken from the mapped data.
+type contextBuffer struct {
+	before int
+	after  int
+	mapped []byte
+	// previous holds the last lines preceding the current block
+	previous []string
+	// held are the matches not released yet, pending their context after
+	held    Matches
+	pending []afterContext
+}
+
+// afterContext is the context after a held match.
+type afterContext struct {
+	// offset of the next context line
+	from  int64
+	lines []string
+	done  bool
+}
+
+func newContextBuffer(before int, after int) *contextBuffer {
+	return &contextBuffer{before: before, after: after}
+}
+
+// add adds the context to the matches found in a block and completes the context
+// of held matches. block is the processed part of the data read, ending on
+// a newline unless the end of the input is reached.
+func (c *contextBuffer) add(block []byte, offset int64, matches Matches, isEOF bool) {
+	if c.mapped != nil {
+		for _, m := range matches {
+			m.ContextBefore = joinLines(lastLines(c.mapped[:m.LineStart], c.before))
+			p := afterContext{done: true}
+			if m.LineEnd < int64(len(c.mapped)) {
+				p.lines = firstLines(c.mapped[m.LineEnd+1:], c.after)
+			}
+			c.held = append(c.held, m)
+			c.pending = append(c.pending, p)
+		}
+		return
+	}
+
+	for i := range c.pending {
+		if !c.pending[i].done {
+			c.collectAfter(&c.pending[i], block, offset, isEOF)
+		}
+	}
+
+	for _, m := range matches {
+		if c.before > 0 {
+			lines := lastLines(block[:m.LineStart-offset], c.before)
+			if missing := c.before - len(lines); missing > 0 && len(c.previous) > 0 {
+				lines = append(lastStrings(c.previous, missing), lines...)
+			}
+			m.ContextBefore = joinLines(lines)
+		}
+		p := afterContext{from: m.LineEnd + 1}
+		c.collectAfter(&p, block, offset, isEOF)
+		c.held = append(c.held, m)
+		c.pending = append(c.pending, p)
+	}
+
+	if c.before > 0 {
+		lines := lastLines(block, c.before)
+		if missing := c.before - len(lines); missing > 0 {
+			lines = append(lastStrings(c.previous, missing), lines...)
+		}
+		c.previous = lines
+	}
+}
+
+// collectAfter adds the lines of block to the context after a match.
+func (c *contextBuffer) collectAfter(p *afterContext, block []byte, offset int64, isEOF bool) {
+	if start := p.from - offset; start <= int64(len(block)) {
+		if start < 0 {
+			start = 0
+		}
+		lines := firstLines(block[start:], c.after-len(p.lines))
+		p.lines = append(p.lines, lines...)
+		p.from = offset + int64(len(block))
+	}
+	p.done = isEOF || len(p.lines) >= c.after
+}
+
+// release returns the held matches whose context is complete,
+// preserving the order of the matches.
+func (c *contextBuffer) release() Matches {
+	n := 0
+	for n < len(c.pending) && c.pending[n].done {
+		c.held[n].ContextAfter = joinLines(c.pending[n].lines)
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	released := make(Matches, n)
+	copy(released, c.held[:n])
+	c.held = c.held[n:]
+	c.pending = c.pending[n:]
+	return released
+}
+
+// flush returns all held matches with the context found so far.
+func (c *contextBuffer) flush() Matches {
+	for i := range c.pending {
+		c.pending[i].done = true
+	}
+	return c.release()
+}
+
+// first returns the first held match, nil if no matches are held.
+func (c *contextBuffer) first() *Match {
+	if len(c.held) == 0 {
+		return nil
+	}
+	return &c.held[0]
+}
+
+// empty checks whether no matches are held.
+func (c *contextBuffer) empty() bool {
+	return len(c.held) == 0
+}
+
+// firstLines returns up to n lines from the beginning of data.
+func firstLines(data []byte, n int) []string {
+	var lines []string
+	for len(lines) < n && len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:end]))
+		data = data[end+1:]
+	}
+	return lines
+}
+
+// lastLines returns up to n lines from the end of data.
+func lastLines(data []byte, n int) []string {
+	if len(data) == 0 || n <= 0 {
+		return nil
+	}
+	end := len(data)
+	if data[end-1] == '\n' {
+		end--
+	}
+	var lines []string
+	for len(lines) < n {
+		start := bytes.LastIndexByte(data[:end], '\n') + 1
+		lines = append(lines, string(data[start:end]))
+		if start == 0 {
+			break
+		}
+		end = start - 1
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
+
+// lastStrings returns up to the last n elements of list.
+func lastStrings(list []string, n int) []string {
+	if len(list) > n {
+		list = list[len(list)-n:]
+	}
+	return append([]string{}, list...)
+}
+
+// joinLines joins context lines, no lines are represented by nil.
+func joinLines(lines []string) *string {
+	if len(lines) == 0 {
+		return nil
+	}
+	str := strings.Join(lines, "\n")
+	return &str
+}
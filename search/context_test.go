@@ -0,0 +1,70 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestContextLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sift-context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var data strings.Builder
+	for i := 1; i <= 40; i++ {
+		if i%3 == 0 {
+			fmt.Fprintf(&data, "match %d\n", i)
+		} else {
+			fmt.Fprintf(&data, "line %d\n", i)
+		}
+	}
+	path := writeTempFile(t, dir, data.String())
+
+	for _, blockSize := range []int{0, 32} {
+		for _, threshold := range []int{-1, 0} {
+			opts := Options{
+				Patterns:           []string{"match"},
+				LineNumbers:        true,
+				ContextBefore:      5,
+				ContextAfter:       5,
+				Limit:              7,
+				BlockSize:          blockSize,
+				StreamingThreshold: threshold,
+			}
+			matches := searchFile(t, opts, path)
+			if len(matches) != 7 {
+				t.Errorf("block size %d, streaming threshold %d: found %d matches, want 7", blockSize, threshold, len(matches))
+				continue
+			}
+			for i, m := range matches {
+				if want := int64(3 * (i + 1)); m.Lineno != want {
+					t.Errorf("block size %d, streaming threshold %d: match %d on line %d, want %d", blockSize, threshold, i, m.Lineno, want)
+				}
+			}
+			last := matches[len(matches)-1]
+			if last.ContextAfter == nil || *last.ContextAfter != "line 22\nline 23\nmatch 24\nline 25\nline 26" {
+				t.Errorf("block size %d, streaming threshold %d: incomplete context after the last match", blockSize, threshold)
+			}
+		}
+	}
+}
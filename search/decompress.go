@@ -0,0 +1,206 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Decompressor describes a compression format that is searched in zip mode.
+type Decompressor struct {
+	// Name is the name of the format, e.g. "gzip".
+	Name string
+	// Extensions are the file extensions (including the dot) of the format.
+	Extensions []string
+	// Magic is the signature at the beginning of compressed data.
+	Magic []byte
+	// NewReader returns a reader for the decompressed data.
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// maxMagicLength is the number of bytes read to detect a format by its signature.
+const maxMagicLength = 16
+
+var decompressors = struct {
+	sync.RWMutex
+	list []Decompressor
+}{}
+
+func init() {
+	RegisterDecompressor(Decompressor{
+		Name:       "gzip",
+		Extensions: []string{".gz", ".tgz"},
+		Magic:      []byte{0x1f, 0x8b},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	})
+	RegisterDecompressor(Decompressor{
+		Name:       "bzip2",
+		Extensions: []string{".bz2", ".tbz2"},
+		Magic:      []byte("BZh"),
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bzip2.NewReader(r)), nil
+		},
+	})
+	RegisterDecompressor(Decompressor{
+		Name:       "xz",
+		Extensions: []string{".xz", ".txz"},
+		Magic:      []byte{0xfd, '7', 'z', 'X', 'Z', 0x00},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			xr, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(xr), nil
+		},
+	})
+	RegisterDecompressor(Decompressor{
+		Name:       "zstd",
+		Extensions: []string{".zst", ".zstd", ".tzst"},
+		Magic:      []byte{0x28, 0xb5, 0x2f, 0xfd},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return zr.IOReadCloser(), nil
+		},
+	})
+	RegisterDecompressor(Decompressor{
+		Name:       "lz4",
+		Extensions: []string{".lz4"},
+		Magic:      []byte{0x04, 0x22, 0x4d, 0x18},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return newLz4Reader(r)
+		},
+	})
+	// legacy frames are detected by their signature, the extension is the same
+	RegisterDecompressor(Decompressor{
+		Name:  "lz4",
+		Magic: []byte{0x02, 0x21, 0x4c, 0x18},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return newLz4Reader(r)
+		},
+	})
+}
+
+// RegisterDecompressor adds a compression format to the formats searched in zip mode.
+// A format registered later takes precedence over existing formats with the same
+// extension or signature.
+func RegisterDecompressor(d Decompressor) {
+	decompressors.Lock()
+	defer decompressors.Unlock()
+	decompressors.list = append([]Decompressor{d}, decompressors.list...)
+}
+
+// Decompressors returns all registered compression formats.
+func Decompressors() []Decompressor {
+	decompressors.RLock()
+	defer decompressors.RUnlock()
+	return append([]Decompressor{}, decompressors.list...)
+}
+
+// decompressorByExtension returns the format matching the extension of filename.
+func decompressorByExtension(filename string) *Decompressor {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == "" {
+		return nil
+	}
+	decompressors.RLock()
+	defer decompressors.RUnlock()
+	for i := range decompressors.list {
+		for _, e := range decompressors.list[i].Extensions {
+			if e == ext {
+				d := decompressors.list[i]
+				return &d
+			}
+		}
+	}
+	return nil
+}
+
+// decompressorByMagic returns the format whose signature matches the beginning of header.
+func decompressorByMagic(header []byte) *Decompressor {
+	decompressors.RLock()
+	defer decompressors.RUnlock()
+	for i := range decompressors.list {
+		magic := decompressors.list[i].Magic
+		if len(magic) > 0 && bytes.HasPrefix(header, magic) {
+			d := decompressors.list[i]
+			return &d
+		}
+	}
+	return nil
+}
+
+// decompressReader detects the compression format of the data read from reader
+// by the extension of filename and the signature of the data.
+// If the data is compressed, a reader for the decompressed data is returned.
+// Otherwise, or if the format cannot be detected for a file with the extension
+// of a compressed format, the returned reader yields the original data.
+// Files that are not compressed are returned unchanged, so that they can still
+// be memory-mapped or split into segments.
+func decompressReader(reader io.Reader, filename string) (io.Reader, io.Closer, error) {
+	if file, ok := reader.(*os.File); ok {
+		// the signature of files supporting ReadAt is read without consuming it
+		header := make([]byte, maxMagicLength)
+		n, err := file.ReadAt(header, 0)
+		if err == nil || err == io.EOF {
+			return decompressHeader(file, header[:n], filename)
+		}
+	}
+	br := bufio.NewReader(reader)
+	header, err := br.Peek(maxMagicLength)
+	if err != nil && err != io.EOF {
+		return br, nil, err
+	}
+	return decompressHeader(br, header, filename)
+}
+
+// decompressHeader returns a reader for the decompressed data of reader, which
+// starts with header. It returns reader if the data is not compressed.
+func decompressHeader(reader io.Reader, header []byte, filename string) (io.Reader, io.Closer, error) {
+	d := decompressorByExtension(filename)
+	if d == nil || (len(d.Magic) > 0 && !bytes.HasPrefix(header, d.Magic)) {
+		magicDecompressor := decompressorByMagic(header)
+		if magicDecompressor == nil && d != nil {
+			return reader, nil, fmt.Errorf("no valid %s data", d.Name)
+		}
+		d = magicDecompressor
+	}
+	if d == nil {
+		return reader, nil, nil
+	}
+	dr, err := d.NewReader(reader)
+	if err != nil {
+		return reader, nil, err
+	}
+	return dr, dr, nil
+}
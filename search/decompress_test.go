@@ -0,0 +1,98 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecompressReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sift-decompress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := strings.Repeat("abcdefg\n", 100)
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	w.Write([]byte(data))
+	w.Close()
+	plainPath := filepath.Join(dir, "plain.txt")
+	gzipPath := filepath.Join(dir, "data.gz")
+	if err := ioutil.WriteFile(plainPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(gzipPath, compressed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(Options{Patterns: []string{"x"}, Cores: 2, SegmentSize: 200})
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	r := s.newRun(context.Background(), nil)
+	tests := []struct {
+		path string
+		// files that are not compressed can still be mapped and split
+		plain bool
+	}{
+		{plainPath, true},
+		{gzipPath, false},
+	}
+	for _, test := range tests {
+		path := test.path
+		infile, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, closer, err := decompressReader(infile, path)
+		if err != nil {
+			t.Fatalf("decompressReader(%s): %s", path, err)
+		}
+		if (reader == io.Reader(infile)) != test.plain {
+			t.Errorf("decompressReader(%s) returned the file itself: %t, want %t", path, !test.plain, test.plain)
+		}
+		if bounds := r.segments(infile, reader); (bounds != nil) != test.plain {
+			t.Errorf("%s: segments = %v, want segments: %t", path, bounds, test.plain)
+		}
+		content, err := ioutil.ReadAll(reader)
+		if err != nil || string(content) != data {
+			t.Errorf("%s: read %d bytes (%v), want the original data", path, len(content), err)
+		}
+		if closer != nil {
+			closer.Close()
+		}
+		infile.Close()
+	}
+
+	// readers without ReadAt keep the peeked signature
+	reader, _, err := decompressReader(strings.NewReader(data), "stdin")
+	if err != nil {
+		t.Fatalf("decompressReader: %s", err)
+	}
+	if content, _ := ioutil.ReadAll(reader); string(content) != data {
+		t.Errorf("decompressReader of a plain reader returned %q, want the original data", content)
+	}
+}
@@ -0,0 +1,548 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/svent/go-nbreader"
+	"github.com/svent/sift/git"
+	"github.com/svent/sift/gitignore"
+)
+
+// processDirectories reads directoryChan and processes
+// directories via processDirectory.
+func (r *searchRun) processDirectories() {
+	n := r.opts.Cores
+	if n > MaxDirRecursionRoutines {
+		n = MaxDirRecursionRoutines
+	}
+	for i := 0; i < n; i++ {
+		go func() {
+			for dirname := range r.directoryChan {
+				r.processDirectory(dirname)
+			}
+		}()
+	}
+}
+
+// sendFile sends a file on filesChan unless the search is aborted.
+func (r *searchRun) sendFile(path string) {
+	select {
+	case r.filesChan <- path:
+	case <-r.ctx.Done():
+	}
+}
+
+// enqueueDirectory enqueues directories on directoryChan.
+// If the channel blocks, the directory is processed directly.
+func (r *searchRun) enqueueDirectory(dirname string) {
+	r.recurseWaitGroup.Add(1)
+	select {
+	case r.directoryChan <- dirname:
+	default:
+		r.processDirectory(dirname)
+	}
+}
+
+// processDirectory recurses into a directory and sends all files
+// fulfilling the selected options on filesChan
+func (r *searchRun) processDirectory(dirname string) {
+	defer r.recurseWaitGroup.Done()
+	if r.ctx.Err() != nil {
+		return
+	}
+	var gic *gitignore.Checker
+	if r.opts.Git || r.opts.GitUntracked {
+		gic = gitignore.NewCheckerWithCache(r.gitignoreCache)
+		err := gic.LoadBasePath(dirname)
+		if err != nil {
+			r.reportError("cannot load gitignore files for path", dirname, err)
+		}
+	}
+	dir, err := os.Open(dirname)
+	if err != nil {
+		r.reportError("cannot open directory", dirname, err)
+		return
+	}
+	defer dir.Close()
+	for {
+		if r.ctx.Err() != nil {
+			return
+		}
+		entries, err := dir.Readdir(256)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			r.reportError("cannot read directory", dirname, err)
+			return
+		}
+
+		for _, fi := range entries {
+			if !fi.IsDir() && r.isTracked(filepath.Join(dirname, fi.Name())) {
+				// already selected from the git index
+				continue
+			}
+			path, isDir, decision := r.selectEntry(dirname, fi, gic)
+			if r.opts.SelectionHandler != nil {
+				r.opts.SelectionHandler(r.selection(filepath.Join(dirname, fi.Name()), fi, path != "", decision, gic))
+			}
+			switch {
+			case path == "":
+			case isDir:
+				r.enqueueDirectory(path)
+			default:
+				r.sendFile(path)
+			}
+		}
+	}
+}
+
+// selectEntry decides whether an entry of the directory dirname is selected.
+// For selected entries, path is the directory to recurse into or the file to
+// search, which differ from the entry for followed symlinks. For skipped
+// entries, path is empty and decision describes the filter excluding the entry.
+func (r *searchRun) selectEntry(dirname string, fi os.FileInfo, gic *gitignore.Checker) (path string, isDir bool, decision filterDecision) {
+	fullpath := filepath.Join(dirname, fi.Name())
+
+	// check directory include/exclude options
+	if fi.IsDir() {
+		if decision := r.dirFilter(fi.Name()); decision.filter != "" {
+			return "", true, decision
+		}
+		if r.opts.Git || r.opts.GitUntracked {
+			if fi.Name() == gitignore.GitFoldername || (gic != nil && gic.Check(fullpath, fi)) {
+				return "", true, filterDecision{filter: r.gitFilter(), value: fi.Name()}
+			}
+		}
+		// the files of nested repositories are not untracked files of this one
+		if r.opts.GitUntracked && git.IsRepositoryRoot(fullpath) {
+			return "", true, filterDecision{filter: "GitUntracked", value: fi.Name(), detail: "repository"}
+		}
+		return fullpath, true, filterDecision{}
+	}
+
+	// check whether this is a regular file
+	path = fullpath
+	if fi.Mode()&os.ModeType != 0 {
+		if fi.Mode()&os.ModeType != os.ModeSymlink {
+			return "", false, filterDecision{value: "not a regular file"}
+		}
+		if !r.opts.FollowSymlinks {
+			return "", false, filterDecision{filter: "FollowSymlinks"}
+		}
+		realPath, err := filepath.EvalSymlinks(fullpath)
+		if err != nil {
+			r.reportError("cannot follow symlink", fullpath, err)
+			return "", false, filterDecision{value: err.Error()}
+		}
+		realFi, err := os.Stat(realPath)
+		if err != nil {
+			r.reportError("cannot follow symlink", fullpath, err)
+			return "", false, filterDecision{value: err.Error()}
+		}
+		if realFi.IsDir() {
+			return realPath, true, filterDecision{}
+		}
+		if realFi.Mode()&os.ModeType != 0 {
+			return "", false, filterDecision{value: "not a regular file"}
+		}
+	}
+
+	if decision := r.fileFilter(fi.Name(), fullpath, true); decision.filter != "" {
+		return "", false, decision
+	}
+
+	if r.opts.Git || r.opts.GitUntracked {
+		// in linked work trees, .git is a file
+		if fi.Name() == gitignore.GitFoldername || (r.opts.Git && gitignore.IsIgnoreFile(fi.Name())) ||
+			(gic != nil && gic.Check(fullpath, fi)) {
+			return "", false, filterDecision{filter: r.gitFilter(), value: fi.Name()}
+		}
+	}
+
+	return path, false, filterDecision{}
+}
+
+// dirFilter returns the option excluding a directory from recursion.
+// It returns the zero filterDecision if the directory is selected.
+func (r *searchRun) dirFilter(name string) filterDecision {
+	if !r.opts.Recursive {
+		return filterDecision{filter: "Recursive"}
+	}
+	for _, dirPattern := range r.opts.ExcludeDirs {
+		if matched, _ := filepath.Match(dirPattern, name); matched {
+			return filterDecision{filter: "ExcludeDirs", value: dirPattern}
+		}
+	}
+	if len(r.opts.IncludeDirs) > 0 {
+		for _, dirPattern := range r.opts.IncludeDirs {
+			if matched, _ := filepath.Match(dirPattern, name); matched {
+				return filterDecision{}
+			}
+		}
+		return filterDecision{filter: "IncludeDirs"}
+	}
+	return filterDecision{}
+}
+
+// gitFilter returns the name of the option that decides about ignored files.
+func (r *searchRun) gitFilter() string {
+	if r.opts.Git {
+		return "Git"
+	}
+	return "GitUntracked"
+}
+
+// includeFile checks whether a file is selected by the path, extension, name and type
+// options. If shebang is set, the first line of the file is checked for file types
+// with a shebang regex. Archives are not checked against the include options,
+// these are applied to the archive members.
+func (r *searchRun) includeFile(name string, fullpath string, shebang bool) bool {
+	return r.fileFilter(name, fullpath, shebang).filter == ""
+}
+
+// fileFilter returns the option excluding a file, see includeFile.
+// It returns the zero filterDecision if the file is selected.
+func (r *searchRun) fileFilter(name string, fullpath string, shebang bool) filterDecision {
+	archive := r.opts.Archives && archiveKindOf(name) != archiveNone
+
+	// check file path options
+	if r.opts.ExcludePath != nil {
+		if r.opts.ExcludePath.MatchString(fullpath) {
+			return filterDecision{filter: "ExcludePath", value: r.opts.ExcludePath.String()}
+		}
+	}
+	if r.opts.IncludePath != nil && !archive {
+		if !r.opts.IncludePath.MatchString(fullpath) {
+			return filterDecision{filter: "IncludePath", value: r.opts.IncludePath.String()}
+		}
+	}
+
+	// check file extension options
+	for _, e := range r.opts.ExcludeExtensions {
+		if filepath.Ext(name) == "."+e {
+			return filterDecision{filter: "ExcludeExtensions", value: e}
+		}
+	}
+	if len(r.opts.IncludeExtensions) > 0 && !archive {
+		for _, e := range r.opts.IncludeExtensions {
+			if filepath.Ext(name) == "."+e {
+				goto includeExtensionFound
+			}
+		}
+		return filterDecision{filter: "IncludeExtensions"}
+	includeExtensionFound:
+	}
+
+	// check file include/exclude options
+	for _, filePattern := range r.opts.ExcludeFiles {
+		if matched, _ := filepath.Match(filePattern, name); matched {
+			return filterDecision{filter: "ExcludeFiles", value: filePattern}
+		}
+	}
+	if len(r.opts.IncludeFiles) > 0 && !archive {
+		for _, filePattern := range r.opts.IncludeFiles {
+			if matched, _ := filepath.Match(filePattern, name); matched {
+				goto includeFileMatchFound
+			}
+		}
+		return filterDecision{filter: "IncludeFiles"}
+	includeFileMatchFound:
+	}
+
+	// check file type options
+	for _, t := range r.opts.ExcludeTypes {
+		for _, filePattern := range r.opts.FileTypes[t].Patterns {
+			if matched, _ := filepath.Match(filePattern, name); matched {
+				return filterDecision{filter: "ExcludeTypes", value: t, detail: filePattern}
+			}
+		}
+		sr := r.opts.FileTypes[t].ShebangRegex
+		if sr != nil && shebang {
+			if m, err := checkShebang(sr, fullpath); m && err == nil {
+				return filterDecision{filter: "ExcludeTypes", value: t, detail: "shebang " + sr.String()}
+			}
+		}
+	}
+	if len(r.opts.IncludeTypes) > 0 && !archive {
+		for _, t := range r.opts.IncludeTypes {
+			for _, filePattern := range r.opts.FileTypes[t].Patterns {
+				if matched, _ := filepath.Match(filePattern, name); matched {
+					goto includeTypeFound
+				}
+			}
+			sr := r.opts.FileTypes[t].ShebangRegex
+			if sr != nil && shebang {
+				if m, err := checkShebang(sr, fullpath); err != nil || m {
+					goto includeTypeFound
+				}
+			}
+		}
+		return filterDecision{filter: "IncludeTypes"}
+	includeTypeFound:
+	}
+	return filterDecision{}
+}
+
+// checkShebang checks whether the first line of file matches the given regex
+func checkShebang(regex *regexp.Regexp, filepath string) (bool, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	b, err := bufio.NewReader(f).ReadBytes('\n')
+	return regex.Match(b), nil
+}
+
+// processFileTargets reads filesChan, builds an io.Reader for the target and calls processReader
+func (r *searchRun) processFileTargets() {
+	defer r.targetsWaitGroup.Done()
+	dataBuffer := make([]byte, r.blockSize)
+	testBuffer := make([]byte, r.blockSize)
+
+	for filepath := range r.filesChan {
+		var err error
+		var infile *os.File
+		var reader io.Reader
+
+		if r.ctx.Err() != nil {
+			// the search was aborted, only drain the channel
+			continue
+		}
+
+		archive := r.opts.Archives && archiveKindOf(filepath) != archiveNone
+		if r.opts.TargetsOnly && !archive {
+			r.resultsChan <- &Result{Target: filepath}
+			continue
+		}
+
+		if !archive && r.skipByIndex(filepath) {
+			r.resultsChan <- &Result{Target: filepath}
+			continue
+		}
+
+		if filepath == "-" {
+			infile = os.Stdin
+		} else {
+			infile, err = os.Open(filepath)
+			if err != nil {
+				r.reportError("cannot open file", filepath, err)
+				continue
+			}
+		}
+
+		// STDIN is read through a contextReader, as closing it does not
+		// unblock a pending read
+		ctx, cancel := r.fileContext()
+		var input io.Reader = infile
+		if infile == os.Stdin {
+			input = &contextReader{ctx: ctx, reader: infile}
+		}
+
+		var decompressor io.Closer
+		if archive {
+			reader = input
+		} else if r.opts.Zip {
+			reader, decompressor, err = decompressReader(input, filepath)
+			if err != nil {
+				if ctx.Err() == nil {
+					r.reportError("error decompressing file", infile.Name(), fmt.Errorf("%s, opening as normal file", err))
+				}
+				infile.Seek(0, 0)
+				reader = input
+			}
+		} else if infile == os.Stdin && r.opts.Multiline {
+			reader = nbreader.NewNBReader(input, r.blockSize,
+				nbreader.ChunkTimeout(MultilinePipeChunkTimeout), nbreader.Timeout(MultilinePipeTimeout))
+		} else {
+			reader = input
+		}
+		var mapped []byte
+		if r.opts.Mmap && !archive && reader == io.Reader(infile) && infile != os.Stdin {
+			// files that cannot be mapped, e.g. pipes, are read
+			if mapped, err = mapFile(infile); err == nil {
+				reader = newMappedReader(mapped)
+			}
+		}
+
+		stop := closeOnDone(ctx, infile)
+		if archive {
+			err = r.processArchive(ctx, reader, filepath, 1, dataBuffer, testBuffer)
+		} else if bounds := r.segments(infile, reader); bounds != nil {
+			err = r.processSegments(ctx, infile, mapped, bounds, filepath)
+		} else if mapped != nil {
+			err = guardMapped(func() error {
+				return r.processReader(ctx, reader, dataBuffer, testBuffer, filepath)
+			})
+		} else {
+			err = r.processReader(ctx, reader, dataBuffer, testBuffer, filepath)
+		}
+		stop()
+		if err != nil && ctx.Err() != nil {
+			// reading failed because the file was closed on timeout
+			err = ctx.Err()
+		}
+		cancel()
+		if err != nil {
+			r.reportTargetError("cannot process data from file", filepath, err)
+		}
+		if decompressor != nil {
+			decompressor.Close()
+		}
+		if mapped != nil {
+			unmapFile(mapped)
+		}
+		infile.Close()
+	}
+}
+
+// closeOnDone closes c when ctx is done to unblock pending reads on network
+// connections and listeners. Closing a file does not unblock reads from it in
+// blocking mode, e.g. from STDIN, see contextReader. The returned function
+// stops watching ctx.
+func closeOnDone(ctx context.Context, c io.Closer) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// contextReader reads from a reader in a separate goroutine, so that a
+// blocking read can be abandoned with an error once ctx is done. The pending
+// read is left behind and its data is discarded.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+	buffer []byte
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if len(c.buffer) < len(p) {
+		c.buffer = make([]byte, len(p))
+	}
+	buffer := c.buffer[:len(p)]
+	done := make(chan readResult, 1)
+	go func() {
+		n, err := c.reader.Read(buffer)
+		done <- readResult{n, err}
+	}()
+	select {
+	case res := <-done:
+		copy(p, buffer[:res.n])
+		return res.n, res.err
+	case <-c.ctx.Done():
+		// the abandoned read may still write to the buffer
+		c.buffer = nil
+		return 0, c.ctx.Err()
+	}
+}
+
+// fileContext returns the context for processing a single target,
+// respecting the file timeout option.
+func (r *searchRun) fileContext() (context.Context, context.CancelFunc) {
+	if r.opts.FileTimeout > 0 {
+		return context.WithTimeout(r.ctx, r.opts.FileTimeout)
+	}
+	return context.WithCancel(r.ctx)
+}
+
+// reportTargetError reports an error returned while processing a target.
+// Errors caused by aborting the whole search are not reported.
+func (r *searchRun) reportTargetError(op string, target string, err error) {
+	if r.ctx.Err() != nil {
+		return
+	}
+	if err == context.DeadlineExceeded {
+		err = ErrFileTimeout
+	}
+	r.reportError(op, target, err)
+}
+
+// processNetworkTarget starts a listening TCP socket and calls processReader
+func (r *searchRun) processNetworkTarget(target string) {
+	defer r.targetsWaitGroup.Done()
+
+	var reader io.Reader
+	netParams := netTcpRegex.FindStringSubmatch(target)
+	proto := netParams[1]
+	addr := netParams[2]
+
+	listener, err := net.Listen(proto, addr)
+	if err != nil {
+		r.reportError("could not listen on", target, err)
+		return
+	}
+	defer listener.Close()
+
+	// close the listener when the search is aborted to unblock Accept
+	stopListener := closeOnDone(r.ctx, listener)
+	conn, err := listener.Accept()
+	stopListener()
+	if err != nil {
+		r.reportTargetError("could not accept connections on", target, err)
+		return
+	}
+	defer conn.Close()
+	ctx, cancel := r.fileContext()
+	defer cancel()
+	defer closeOnDone(ctx, conn)()
+
+	if r.opts.Multiline {
+		reader = nbreader.NewNBReader(conn, r.blockSize, nbreader.ChunkTimeout(MultilinePipeChunkTimeout),
+			nbreader.Timeout(MultilinePipeTimeout))
+	} else {
+		reader = conn
+	}
+
+	dataBuffer := make([]byte, r.blockSize)
+	testBuffer := make([]byte, r.blockSize)
+	err = r.processReader(ctx, reader, dataBuffer, testBuffer, target)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		r.reportTargetError("error processing data from", target, err)
+		return
+	}
+}
@@ -13,14 +13,15 @@
 // You should have received a copy of the GNU General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-package main
+package search
 
 import (
 	"regexp"
 )
 
-func init() {
-	global.fileTypesMap = map[string]FileType{
+// DefaultFileTypes returns the built-in file types.
+func DefaultFileTypes() map[string]FileType {
+	return map[string]FileType{
 		"go": FileType{
 			Patterns: []string{"*.go"},
 		},
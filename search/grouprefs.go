@@ -0,0 +1,196 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// Condition patterns can reference named groups of the search patterns with
+// (?P=name). Such a condition is fulfilled for a match if the condition pattern
+// with the reference replaced by the text captured by the match is found.
+//
+// While reading a target, the references are replaced by the definitions of
+// the groups, so all candidates for condition matches are found. When the
+// conditions are applied, the lines of the candidates are searched again with
+// the captured text of each match.
+
+// groupRef is a reference (?P=name) in a condition pattern.
+type groupRef struct {
+	start int
+	end   int
+	name  string
+}
+
+// findGroupRefs returns the group references in a pattern.
+func findGroupRefs(pattern string) []groupRef {
+	var refs []groupRef
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' {
+			i++
+			continue
+		}
+		if !strings.HasPrefix(pattern[i:], "(?P=") {
+			continue
+		}
+		end := strings.IndexByte(pattern[i:], ')')
+		if end < 0 {
+			break
+		}
+		refs = append(refs, groupRef{start: i, end: i + end + 1, name: pattern[i+len("(?P=") : i+end]})
+		i += end
+	}
+	return refs
+}
+
+// replaceGroupRefs replaces the group references in a pattern.
+func replaceGroupRefs(pattern string, refs []groupRef, replacement func(name string) string) string {
+	var buf strings.Builder
+	pos := 0
+	for _, ref := range refs {
+		buf.WriteString(pattern[pos:ref.start])
+		buf.WriteString(replacement(ref.name))
+		pos = ref.end
+	}
+	buf.WriteString(pattern[pos:])
+	return buf.String()
+}
+
+// groupDefinition returns a pattern matching what the named group matches in
+// any of the search patterns.
+func groupDefinition(patterns []string, name string) (string, bool) {
+	var definitions []string
+	var collect func(re *syntax.Regexp)
+	collect = func(re *syntax.Regexp) {
+		if re.Op == syntax.OpCapture && re.Name == name {
+			definitions = append(definitions, re.Sub[0].String())
+		}
+		for _, sub := range re.Sub {
+			collect(sub)
+		}
+	}
+	for _, pattern := range patterns {
+		if re, err := syntax.Parse(pattern, syntax.Perl); err == nil {
+			collect(re)
+		}
+	}
+	if len(definitions) == 0 {
+		return "", false
+	}
+	return "(?:" + strings.Join(definitions, "|") + ")", true
+}
+
+// conditionPattern returns the pattern used to search for candidates of condition
+// matches. References to groups are replaced by the definitions of the groups.
+func (s *Searcher) conditionPattern(c *condition) (string, error) {
+	if s.opts.Literal {
+		return c.Pattern, nil
+	}
+	c.groupRefs = findGroupRefs(c.Pattern)
+	if len(c.groupRefs) == 0 {
+		return c.Pattern, nil
+	}
+	switch c.Type {
+	case ConditionPreceded, ConditionFollowed, ConditionSurrounded,
+		ConditionPrecededBytes, ConditionFollowedBytes, ConditionSurroundedBytes:
+	default:
+		return "", fmt.Errorf("cannot parse condition pattern '%s': group references are only supported by preceded, followed and surrounded conditions", c.Pattern)
+	}
+	definitions := make(map[string]string)
+	for _, ref := range c.groupRefs {
+		definition, ok := groupDefinition(s.opts.Patterns, ref.name)
+		if !ok {
+			return "", fmt.Errorf("cannot parse condition pattern '%s': no group named '%s' in the search patterns", c.Pattern, ref.name)
+		}
+		definitions[ref.name] = definition
+	}
+	return replaceGroupRefs(c.Pattern, c.groupRefs, func(name string) string {
+		return definitions[name]
+	}), nil
+}
+
+// matchGroups holds the named groups captured by a match and the condition
+// regexes with the references replaced by them.
+type matchGroups struct {
+	match   *Match
+	values  map[string]string
+	regexes map[int]*regexp.Regexp
+}
+
+// conditionFulfilled checks whether a preceded/followed/surrounded condition
+// is fulfilled for a match by a condition match.
+func (s *Searcher) conditionFulfilled(conditionMatch *Match, groups *matchGroups) bool {
+	c := &s.conditions[conditionMatch.conditionID]
+	if len(c.groupRefs) == 0 {
+		return c.fulfilledBy(conditionMatch, groups.match)
+	}
+	re := s.groupRefRegex(conditionMatch.conditionID, groups)
+	if re == nil {
+		return false
+	}
+	// the text found for the candidate can differ from the text captured by the
+	// match, so the lines of the candidate are searched again
+	testLine := s.testString(conditionMatch.Line)
+	for _, index := range re.FindAllStringIndex(testLine, -1) {
+		m := *conditionMatch
+		m.Start = m.LineStart + int64(index[0])
+		m.End = m.LineStart + int64(index[1])
+		m.Lineno += int64(strings.Count(testLine[:index[0]], "\n"))
+		if c.fulfilledBy(&m, groups.match) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupRefRegex returns the regex of a condition with the references replaced by
+// the text captured by the match, nil if a referenced group did not participate
+// in the match.
+func (s *Searcher) groupRefRegex(conditionID int, groups *matchGroups) *regexp.Regexp {
+	if re, ok := groups.regexes[conditionID]; ok {
+		return re
+	}
+	if groups.values == nil {
+		groups.values = make(map[string]string)
+		if re, index := s.submatchIndex(groups.match); index != nil {
+			for group, name := range re.SubexpNames() {
+				if _, ok := groups.values[name]; name != "" && !ok && index[2*group] >= 0 {
+					groups.values[name] = groups.match.Line[index[2*group]:index[2*group+1]]
+				}
+			}
+		}
+		groups.regexes = make(map[int]*regexp.Regexp)
+	}
+
+	c := &s.conditions[conditionID]
+	missing := false
+	pattern := replaceGroupRefs(c.Pattern, c.groupRefs, func(name string) string {
+		value, ok := groups.values[name]
+		if !ok {
+			missing = true
+		}
+		return regexp.QuoteMeta(value)
+	})
+	var re *regexp.Regexp
+	if !missing {
+		re, _ = regexp.Compile(s.preparePattern(pattern))
+	}
+	groups.regexes[conditionID] = re
+	return re
+}
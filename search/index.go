@@ -0,0 +1,64 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"regexp/syntax"
+	"strings"
+
+	"github.com/svent/sift/index"
+)
+
+// indexQuery returns the index query for the files that can contain a match
+// of any of the search patterns.
+func (s *Searcher) indexQuery() *index.Query {
+	queries := make([]*index.Query, len(s.opts.Patterns))
+	for i, pattern := range s.opts.Patterns {
+		re, err := syntax.Parse(s.preparePattern(pattern), syntax.Perl)
+		if err != nil {
+			return &index.Query{Op: index.QueryAll}
+		}
+		queries[i] = index.RegexpQuery(re)
+	}
+	return index.OrQuery(queries...)
+}
+
+// skipByIndex checks whether the index shows that a file cannot contain a match.
+// Files that are not indexed or were modified since are not skipped.
+func (s *Searcher) skipByIndex(path string) bool {
+	if s.index == nil || path == "-" {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(s.index.Root(), abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	f, ok := s.index.Lookup(filepath.ToSlash(rel))
+	if !ok {
+		return false
+	}
+	fileinfo, err := os.Stat(path)
+	if err != nil || !f.Current(fileinfo) {
+		return false
+	}
+	return !s.indexCandidates[f.ID]
+}
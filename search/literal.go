@@ -0,0 +1,287 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"bytes"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// MinLiteralPatterns is the minimum number of patterns for which the
+// Aho-Corasick matcher is used if all patterns are literals. For fewer
+// patterns, the regex engine with its optimized literal search is faster.
+const MinLiteralPatterns = 24
+
+// literalMatcher finds the occurrences of multiple literal patterns in a single
+// pass over the data using the Aho-Corasick algorithm.
+type literalMatcher struct {
+	lengths []int
+	// wordBoundaries requires matches to start and end on ASCII word boundaries
+	wordBoundaries bool
+
+	// root holds the transitions of the root state for all bytes
+	root [256]int32
+	// the transitions of state s are edgeBytes/edgeNext[edgeStart[s]:edgeStart[s+1]]
+	edgeStart []int32
+	edgeBytes []byte
+	edgeNext  []int32
+	fail      []int32
+	// out is the pattern ending in a state (-1 = none), dict is the next
+	// state with an output on the fail chain (0 = none)
+	out  []int32
+	dict []int32
+}
+
+// literalPatterns returns the literal strings the patterns consist of, prepared
+// like the regex patterns. ok is false if any pattern is not a plain literal.
+func (s *Searcher) literalPatterns(patterns []string) (literals []string, ok bool) {
+	if s.foldCase {
+		return nil, false
+	}
+	for _, pattern := range patterns {
+		if s.opts.Literal {
+			if s.opts.IgnoreCase {
+				pattern = strings.ToLower(pattern)
+			}
+		} else {
+			if s.opts.IgnoreCase {
+				pattern = lowercasePattern(pattern, s.opts.Multiline)
+			}
+			re, err := syntax.Parse(pattern, syntax.Perl)
+			if err != nil || re.Op != syntax.OpLiteral || re.Flags&syntax.FoldCase != 0 {
+				return nil, false
+			}
+			pattern = string(re.Rune)
+		}
+		// newlines need the special handling of the regex engine
+		if pattern == "" || strings.Contains(pattern, "\n") {
+			return nil, false
+		}
+		literals = append(literals, pattern)
+	}
+	return literals, true
+}
+
+// newLiteralMatcher builds the automaton for the given patterns.
+func newLiteralMatcher(patterns []string, wordBoundaries bool) *literalMatcher {
+	type edge struct {
+		b    byte
+		next int32
+	}
+	// build the trie
+	children := [][]edge{nil}
+	out := []int32{-1}
+	for id, pattern := range patterns {
+		state := int32(0)
+		for i := 0; i < len(pattern); i++ {
+			next := int32(-1)
+			for _, e := range children[state] {
+				if e.b == pattern[i] {
+					next = e.next
+					break
+				}
+			}
+			if next < 0 {
+				next = int32(len(children))
+				children = append(children, nil)
+				out = append(out, -1)
+				children[state] = append(children[state], edge{pattern[i], next})
+			}
+			state = next
+		}
+		// for duplicate patterns, the first one is reported
+		if out[state] < 0 {
+			out[state] = int32(id)
+		}
+	}
+
+	m := &literalMatcher{
+		wordBoundaries: wordBoundaries,
+		edgeStart:      make([]int32, len(children)+1),
+		fail:           make([]int32, len(children)),
+		out:            out,
+		dict:           make([]int32, len(children)),
+	}
+	for _, pattern := range patterns {
+		m.lengths = append(m.lengths, len(pattern))
+	}
+	for s, edges := range children {
+		sort.Slice(edges, func(i, j int) bool { return edges[i].b < edges[j].b })
+		m.edgeStart[s] = int32(len(m.edgeBytes))
+		for _, e := range edges {
+			m.edgeBytes = append(m.edgeBytes, e.b)
+			m.edgeNext = append(m.edgeNext, e.next)
+		}
+	}
+	m.edgeStart[len(children)] = int32(len(m.edgeBytes))
+	for _, e := range children[0] {
+		m.root[e.b] = e.next
+	}
+
+	// compute the fail and dictionary links in breadth-first order
+	queue := make([]int32, 0, len(children))
+	for _, e := range children[0] {
+		queue = append(queue, e.next)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for _, e := range children[state] {
+			f := m.fail[state]
+			for {
+				if next := m.next(f, e.b); next >= 0 {
+					m.fail[e.next] = next
+					break
+				}
+				if f == 0 {
+					m.fail[e.next] = 0
+					break
+				}
+				f = m.fail[f]
+			}
+			if fail := m.fail[e.next]; m.out[fail] >= 0 {
+				m.dict[e.next] = fail
+			} else {
+				m.dict[e.next] = m.dict[fail]
+			}
+			queue = append(queue, e.next)
+		}
+	}
+	return m
+}
+
+// next returns the transition of state for byte b, or -1 if there is none.
+func (m *literalMatcher) next(state int32, b byte) int32 {
+	if state == 0 {
+		return m.root[b]
+	}
+	for i := m.edgeStart[state]; i < m.edgeStart[state+1]; i++ {
+		if m.edgeBytes[i] == b {
+			return m.edgeNext[i]
+		} else if m.edgeBytes[i] > b {
+			break
+		}
+	}
+	return -1
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+// isWordBoundary checks for an ASCII word boundary (\b) at position pos of data.
+func isWordBoundary(data []byte, pos int) bool {
+	before := pos > 0 && isWordByte(data[pos-1])
+	after := pos < len(data) && isWordByte(data[pos])
+	return before != after
+}
+
+// literalIndex is the position of a match of a literal pattern.
+type literalIndex struct {
+	start     int
+	end       int
+	patternID int
+}
+
+// findAll returns the non-overlapping matches of each pattern in data, sorted by
+// start and pattern. Matches of different patterns may overlap, like the matches
+// of separate regexes. If firstPerLine is set, only the first match of each line
+// is returned, as only that one is kept when matching line by line.
+func (m *literalMatcher) findAll(data []byte, firstPerLine bool) []literalIndex {
+	var indexes []literalIndex
+	// the end of the last match per pattern
+	lastEnd := make([]int, len(m.lengths))
+	// the first match of the current line and the end of that line
+	first := literalIndex{start: -1}
+	firstLineEnd := 0
+	state := int32(0)
+	for i := 0; i < len(data); i++ {
+		if state == 0 {
+			// fast path while no pattern is started
+			for i < len(data) && m.root[data[i]] == 0 {
+				i++
+			}
+			if i == len(data) {
+				break
+			}
+			state = m.root[data[i]]
+		} else {
+			b := data[i]
+			for {
+				if next := m.next(state, b); next >= 0 {
+					state = next
+					break
+				}
+				state = m.fail[state]
+				if state == 0 {
+					state = m.root[b]
+					break
+				}
+			}
+			if state == 0 {
+				continue
+			}
+		}
+		s := state
+		if m.out[s] < 0 {
+			s = m.dict[s]
+		}
+		for ; s != 0; s = m.dict[s] {
+			id := int(m.out[s])
+			end := i + 1
+			start := end - m.lengths[id]
+			if start < lastEnd[id] {
+				continue
+			}
+			if m.wordBoundaries && !(isWordBoundary(data, start) && isWordBoundary(data, end)) {
+				continue
+			}
+			lastEnd[id] = end
+			index := literalIndex{start: start, end: end, patternID: id}
+			switch {
+			case !firstPerLine:
+				indexes = append(indexes, index)
+			case first.start >= 0 && start > firstLineEnd:
+				// the match is on a new line
+				indexes = append(indexes, first)
+				fallthrough
+			case first.start < 0:
+				first = index
+				firstLineEnd = len(data)
+				if pos := bytes.IndexByte(data[end:], '\n'); pos >= 0 {
+					firstLineEnd = end + pos
+				}
+			case start < first.start || (start == first.start && id < first.patternID):
+				first = index
+			}
+		}
+	}
+	if firstPerLine {
+		if first.start >= 0 {
+			indexes = append(indexes, first)
+		}
+		return indexes
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		if indexes[i].start != indexes[j].start {
+			return indexes[i].start < indexes[j].start
+		}
+		return indexes[i].patternID < indexes[j].patternID
+	})
+	return indexes
+}
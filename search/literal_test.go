@@ -0,0 +1,103 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+// regexLiteralMatches returns the matches findAll is expected to return,
+// found with one regex per pattern.
+func regexLiteralMatches(patterns []string, data string, wordBoundaries bool) []literalIndex {
+	var indexes []literalIndex
+	for id, pattern := range patterns {
+		expr := regexp.QuoteMeta(pattern)
+		if wordBoundaries {
+			expr = `\b` + expr + `\b`
+		}
+		for _, loc := range regexp.MustCompile(expr).FindAllStringIndex(data, -1) {
+			indexes = append(indexes, literalIndex{start: loc[0], end: loc[1], patternID: id})
+		}
+	}
+	// duplicate patterns are only reported once, for the first one
+	type occurrence struct {
+		pattern string
+		start   int
+	}
+	var unique []literalIndex
+	seen := map[occurrence]bool{}
+	for _, index := range indexes {
+		key := occurrence{patterns[index.patternID], index.start}
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, index)
+		}
+	}
+	sort.SliceStable(unique, func(i, j int) bool {
+		if unique[i].start != unique[j].start {
+			return unique[i].start < unique[j].start
+		}
+		return unique[i].patternID < unique[j].patternID
+	})
+	return unique
+}
+
+func TestLiteralMatcherFindAll(t *testing.T) {
+	tests := []struct {
+		patterns       []string
+		data           string
+		wordBoundaries bool
+	}{
+		{[]string{"he", "she", "his", "hers"}, "ushers and his sheep", false},
+		{[]string{"a", "ab", "bc", "abc"}, "abcabc\nxabc", false},
+		{[]string{"aa"}, "aaaaa", false},
+		{[]string{"aa", "aaa"}, "aaaaaaa", false},
+		{[]string{"foo", "foo", "bar"}, "foobar foo", false},
+		{[]string{"foo", "bar"}, "foo foobar bar_ (bar)", true},
+		{[]string{"x"}, "", false},
+		{[]string{"abcd", "bcd", "cd", "d"}, "abcdabcd", false},
+		{[]string{"über", "ü", "er"}, "Müller über über", false},
+	}
+	for _, test := range tests {
+		m := newLiteralMatcher(test.patterns, test.wordBoundaries)
+		got := m.findAll([]byte(test.data), false)
+		want := regexLiteralMatches(test.patterns, test.data, test.wordBoundaries)
+		if len(got) == 0 && len(want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("findAll(%q, %q) = %v, want %v", test.patterns, test.data, got, want)
+		}
+	}
+}
+
+func TestLiteralMatcherFirstPerLine(t *testing.T) {
+	patterns := []string{"cd", "abcd", "x"}
+	data := "abcd cd\nnone\nx abcd\ncd"
+	m := newLiteralMatcher(patterns, false)
+	got := m.findAll([]byte(data), true)
+	want := []literalIndex{
+		{start: 0, end: 4, patternID: 1},
+		{start: 13, end: 14, patternID: 2},
+		{start: 20, end: 22, patternID: 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findAll(%q, firstPerLine) = %v, want %v", data, got, want)
+	}
+}
@@ -0,0 +1,321 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// lz4Reader decompresses data in the LZ4 frame format
+// (https://github.com/lz4/lz4/blob/dev/doc/lz4_Frame_format.md),
+// including legacy frames as written by "lz4 -l". Checksums are not verified.
+type lz4Reader struct {
+	r io.Reader
+	// flags of the current frame
+	blockChecksum   bool
+	contentChecksum bool
+	maxBlockSize    int
+	inFrame         bool
+	legacy          bool
+	// buf holds the history for linked blocks followed by the decompressed
+	// data of the current block, pos is the read position in buf
+	buf   []byte
+	pos   int
+	block []byte
+	hdr   [8]byte
+}
+
+const (
+	lz4FrameMagic        = 0x184D2204
+	lz4LegacyMagic       = 0x184C2102
+	lz4SkippableMagic    = 0x184D2A50
+	lz4SkippableMask     = 0xFFFFFFF0
+	lz4WindowSize        = 64 * 1024
+	lz4MinMatch          = 4
+	lz4UncompressedBlock = 1 << 31
+	// legacy frames consist of blocks of 8 MB, the size of compressed
+	// blocks is limited by the worst case of incompressible data
+	lz4LegacyBlockSize      = 8 * 1024 * 1024
+	lz4LegacyCompressedSize = lz4LegacyBlockSize + lz4LegacyBlockSize/255 + 16
+)
+
+var errLz4Corrupt = errors.New("lz4: corrupt input")
+
+func newLz4Reader(r io.Reader) (io.ReadCloser, error) {
+	z := &lz4Reader{r: r}
+	if err := z.readFrameHeader(true); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// readFrameHeader reads the next frame header, skipping skippable frames.
+// At the end of the stream io.EOF is returned, unless first is set.
+func (z *lz4Reader) readFrameHeader(first bool) error {
+	_, err := io.ReadFull(z.r, z.hdr[:4])
+	if err != nil {
+		if err == io.EOF && !first {
+			return io.EOF
+		}
+		return errLz4Corrupt
+	}
+	return z.startFrame(binary.LittleEndian.Uint32(z.hdr[:4]))
+}
+
+// startFrame reads the rest of the header of a frame starting with magic.
+func (z *lz4Reader) startFrame(magic uint32) error {
+	for magic&lz4SkippableMask == lz4SkippableMagic {
+		if _, err := io.ReadFull(z.r, z.hdr[:4]); err != nil {
+			return errLz4Corrupt
+		}
+		size := int64(binary.LittleEndian.Uint32(z.hdr[:4]))
+		if _, err := io.CopyN(ioutil.Discard, z.r, size); err != nil {
+			return errLz4Corrupt
+		}
+		if _, err := io.ReadFull(z.r, z.hdr[:4]); err != nil {
+			return errLz4Corrupt
+		}
+		magic = binary.LittleEndian.Uint32(z.hdr[:4])
+	}
+	z.buf = z.buf[:0]
+	z.pos = 0
+	if magic == lz4LegacyMagic {
+		// legacy frames have no header and end with the stream
+		// or the beginning of the next frame
+		z.legacy = true
+		z.blockChecksum = false
+		z.contentChecksum = false
+		z.maxBlockSize = lz4LegacyCompressedSize
+		z.inFrame = true
+		return nil
+	}
+	if magic != lz4FrameMagic {
+		return errLz4Corrupt
+	}
+	z.legacy = false
+
+	if _, err := io.ReadFull(z.r, z.hdr[:2]); err != nil {
+		return errLz4Corrupt
+	}
+	flg, bd := z.hdr[0], z.hdr[1]
+	if flg>>6 != 1 {
+		return errors.New("lz4: unsupported frame version")
+	}
+	z.blockChecksum = flg&0x10 != 0
+	z.contentChecksum = flg&0x04 != 0
+	switch (bd >> 4) & 0x07 {
+	case 4:
+		z.maxBlockSize = 64 * 1024
+	case 5:
+		z.maxBlockSize = 256 * 1024
+	case 6:
+		z.maxBlockSize = 1024 * 1024
+	case 7:
+		z.maxBlockSize = 4 * 1024 * 1024
+	default:
+		return errLz4Corrupt
+	}
+	// skip content size, dictionary id and header checksum
+	skip := 1
+	if flg&0x08 != 0 {
+		skip += 8
+	}
+	if flg&0x01 != 0 {
+		skip += 4
+	}
+	if _, err := io.CopyN(ioutil.Discard, z.r, int64(skip)); err != nil {
+		return errLz4Corrupt
+	}
+	z.inFrame = true
+	return nil
+}
+
+// readBlock decompresses the next block of the current frame into buf.
+func (z *lz4Reader) readBlock() error {
+	if _, err := io.ReadFull(z.r, z.hdr[:4]); err != nil {
+		if err == io.EOF && z.legacy {
+			return io.EOF
+		}
+		return errLz4Corrupt
+	}
+	size := binary.LittleEndian.Uint32(z.hdr[:4])
+	if z.legacy {
+		return z.readLegacyBlock(size)
+	}
+	if size == 0 {
+		// end mark
+		z.inFrame = false
+		if z.contentChecksum {
+			if _, err := io.ReadFull(z.r, z.hdr[:4]); err != nil {
+				return errLz4Corrupt
+			}
+		}
+		return nil
+	}
+	uncompressed := size&lz4UncompressedBlock != 0
+	size &^= lz4UncompressedBlock
+	if int(size) > z.maxBlockSize {
+		return errLz4Corrupt
+	}
+	if cap(z.block) < int(size) {
+		z.block = make([]byte, z.maxBlockSize)
+	}
+	block := z.block[:size]
+	if _, err := io.ReadFull(z.r, block); err != nil {
+		return errLz4Corrupt
+	}
+	if z.blockChecksum {
+		if _, err := io.ReadFull(z.r, z.hdr[:4]); err != nil {
+			return errLz4Corrupt
+		}
+	}
+
+	// keep the last 64 KB as history for the matches of the next block
+	if len(z.buf) > lz4WindowSize {
+		n := copy(z.buf, z.buf[len(z.buf)-lz4WindowSize:])
+		z.buf = z.buf[:n]
+	}
+	z.pos = len(z.buf)
+	if uncompressed {
+		z.buf = append(z.buf, block...)
+		return nil
+	}
+	buf, err := lz4DecodeBlock(z.buf, block)
+	if err != nil {
+		return err
+	}
+	z.buf = buf
+	return nil
+}
+
+// readLegacyBlock decompresses a block of a legacy frame, whose blocks are
+// independent of each other. A size larger than a block is the magic number
+// of the next frame.
+func (z *lz4Reader) readLegacyBlock(size uint32) error {
+	if size > lz4LegacyCompressedSize {
+		return z.startFrame(size)
+	}
+	if cap(z.block) < int(size) {
+		z.block = make([]byte, size)
+	}
+	block := z.block[:size]
+	if _, err := io.ReadFull(z.r, block); err != nil {
+		return errLz4Corrupt
+	}
+	buf, err := lz4DecodeBlock(z.buf[:0], block)
+	if err != nil {
+		return err
+	}
+	if len(buf) > lz4LegacyBlockSize {
+		return errLz4Corrupt
+	}
+	z.buf = buf
+	z.pos = 0
+	return nil
+}
+
+// lz4DecodeBlock decodes a compressed block and appends the result to dst.
+// The data already in dst is used as history for matches.
+func lz4DecodeBlock(dst []byte, src []byte) ([]byte, error) {
+	i := 0
+	for i < len(src) {
+		token := src[i]
+		i++
+
+		literals := int(token >> 4)
+		if literals == 15 {
+			for {
+				if i >= len(src) {
+					return nil, errLz4Corrupt
+				}
+				b := src[i]
+				i++
+				literals += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		if i+literals > len(src) {
+			return nil, errLz4Corrupt
+		}
+		dst = append(dst, src[i:i+literals]...)
+		i += literals
+		if i == len(src) {
+			// the last sequence only contains literals
+			break
+		}
+
+		if i+2 > len(src) {
+			return nil, errLz4Corrupt
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if offset == 0 || offset > len(dst) {
+			return nil, errLz4Corrupt
+		}
+		matchLength := int(token & 0x0f)
+		if matchLength == 15 {
+			for {
+				if i >= len(src) {
+					return nil, errLz4Corrupt
+				}
+				b := src[i]
+				i++
+				matchLength += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		matchLength += lz4MinMatch
+
+		start := len(dst) - offset
+		if offset >= matchLength {
+			dst = append(dst, dst[start:start+matchLength]...)
+		} else {
+			// overlapping match, copy byte by byte
+			for j := 0; j < matchLength; j++ {
+				dst = append(dst, dst[start+j])
+			}
+		}
+	}
+	return dst, nil
+}
+
+func (z *lz4Reader) Read(p []byte) (int, error) {
+	for z.pos == len(z.buf) {
+		if !z.inFrame {
+			if err := z.readFrameHeader(false); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err := z.readBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, z.buf[z.pos:])
+	z.pos += n
+	return n, nil
+}
+
+func (z *lz4Reader) Close() error {
+	return nil
+}
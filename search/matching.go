@@ -0,0 +1,709 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"sort"
+)
+
+// processReader is the main routine working on an io.Reader.
+// It stops processing and returns the context's error when ctx is done.
+// The data of a mappedReader is searched in place, block by block, without
+// limiting the length of lines to the size of the buffer.
+// For other readers, the buffers grow up to Options.MaxLineLength if a line
+// does not fit into them.
+func (r *searchRun) processReader(ctx context.Context, reader io.Reader, data []byte, testBuffer []byte, target string) error {
+	var (
+		bufferOffset             int
+		err                      error
+		isEOF                    bool
+		lastCoveredLineEnd       int64 = -1
+		lastInputBlockSize       int
+		lastMatch                *Match
+		lastRoundMultilineWindow bool
+		lastSeekAmount           int
+		lastValidMatchRange      int
+		limitReached             bool
+		linecount                int64 = 1
+		longLineStart            int64
+		matchChan                chan Matches
+		matchCount               int64
+		reportedCount            int64
+		offset                   int64
+		resultIsBinary           bool
+		resultStreaming          bool
+		validMatchRange          int
+	)
+	matches := make([]Match, 0, 16)
+	ranges := r.lineRanges(target)
+	conditionMatches := make([]Match, 0, 16)
+	var contextLines *contextBuffer
+	if r.opts.ContextBefore > 0 || r.opts.ContextAfter > 0 {
+		contextLines = newContextBuffer(r.opts.ContextBefore, r.opts.ContextAfter)
+	}
+	var conditions *conditionEvaluator
+	if len(r.conditions) > 0 {
+		conditions = newConditionEvaluator(r.Searcher)
+	}
+	var mapped []byte
+	// window is the size of the blocks of mapped data
+	window := r.blockSize
+	// skip is the number of bytes at the beginning of a block
+	// that belong to the previous window of a long line
+	var skip int
+	if m, ok := reader.(*mappedReader); ok {
+		mapped = m.data
+		if contextLines != nil {
+			contextLines.mapped = mapped
+		}
+	}
+
+	for {
+		if isEOF {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var length int
+		// splitLine is set if the block is a window of a line exceeding the buffer
+		var splitLine bool
+		// condition matches are passed to the condition evaluator after each block
+		conditionMatches = conditionMatches[:0]
+		lastConditionMatch := -1
+
+		if mapped != nil {
+			// a block starts at the first byte not processed yet
+			length = len(mapped) - int(offset)
+			if length > window {
+				length = window
+			}
+			data = mapped[offset : offset+int64(length)]
+			isEOF = offset+int64(length) == int64(len(mapped))
+			validMatchRange = length
+			if r.opts.Multiline && !isEOF && length > InputMultilineWindow {
+				validMatchRange = length - InputMultilineWindow
+			}
+			lastInputBlockSize = length
+		} else if r.opts.Multiline {
+			if lastRoundMultilineWindow {
+				// if the last input block was greater than the sliding window size, that last part has to be processed again
+				copy(data[bufferOffset:bufferOffset+InputMultilineWindow], data[lastInputBlockSize-InputMultilineWindow:lastInputBlockSize])
+				length, err = reader.Read(data[bufferOffset+InputMultilineWindow:])
+				length += bufferOffset
+				length += InputMultilineWindow
+			} else {
+				length, err = reader.Read(data[bufferOffset:])
+				length += bufferOffset
+			}
+			if err != nil {
+				if err == io.EOF {
+					isEOF = true
+				} else {
+					return err
+				}
+			}
+			lastInputBlockSize = length
+
+			// if the input block was greater than the sliding window size, only process a part of it
+			if !isEOF && length > InputMultilineWindow {
+				validMatchRange = length - InputMultilineWindow
+				lastRoundMultilineWindow = true
+			} else {
+				validMatchRange = length
+				lastRoundMultilineWindow = false
+			}
+		} else {
+			// single line mode
+			length, err = reader.Read(data[bufferOffset:])
+			if err != nil {
+				if err == io.EOF {
+					isEOF = true
+				} else {
+					return err
+				}
+			}
+			length += bufferOffset
+			validMatchRange = length
+			lastInputBlockSize = length
+		}
+
+		lastValidMatchRange = validMatchRange
+
+		// check if file is binary (0x00 found in first 256 bytes)
+		if offset == 0 {
+			s := 256
+			if length < s {
+				s = length
+			}
+			for i := 0; i < s; i++ {
+				if data[i] == 0 {
+					resultIsBinary = true
+					if r.opts.BinarySkip {
+						return nil
+					}
+					break
+				}
+			}
+		}
+
+		// adjust validMatchRange to end on a newline
+		lastSeekAmount = 0
+		if !isEOF {
+			newLineFound := false
+			var pos int
+			for pos = validMatchRange - 1; pos >= 0; pos-- {
+				if data[pos] == '\n' {
+					newLineFound = true
+					break
+				}
+			}
+			if newLineFound {
+				lastSeekAmount = validMatchRange - 1 - pos
+				validMatchRange = validMatchRange - lastSeekAmount
+				bufferOffset = 0
+				window = r.blockSize
+			} else {
+				switch {
+				case mapped != nil && (r.opts.MaxLineLength == 0 || window < r.opts.MaxLineLength):
+					// search a larger block of the mapped data
+					window *= 2
+					if r.opts.MaxLineLength != 0 && window > r.opts.MaxLineLength {
+						window = r.opts.MaxLineLength
+					}
+					continue
+				case mapped != nil:
+				case lastInputBlockSize < len(data):
+					// the buffer is not full yet
+					bufferOffset = validMatchRange
+					continue
+				case len(data) < r.opts.MaxLineLength:
+					data = growBuffer(data, length, r.opts.MaxLineLength)
+					bufferOffset = validMatchRange
+					continue
+				}
+				if !r.opts.SplitLongLines {
+					return ErrLineTooLong
+				}
+				// search the line in windows, the end of the window is searched
+				// again with the next one to find matches crossing the border
+				splitLine = true
+				if !r.opts.Multiline {
+					lastSeekAmount = LongLineOverlap
+					validMatchRange -= lastSeekAmount
+				}
+				// the bytes before the next window are kept for assertions like ^ or \b
+				lastSeekAmount += longLineLookbehind
+				bufferOffset = 0
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			// the block may have been read after the file timeout,
+			// the results of the target are dropped
+			return err
+		}
+
+		var testDataPtr []byte
+		if r.lowercaseInput() {
+			if len(testBuffer) < length {
+				testBuffer = make([]byte, length)
+			}
+			bytesToLower(data, testBuffer, length)
+			testDataPtr = testBuffer[0:length]
+		} else {
+			testDataPtr = data[0:length]
+		}
+
+		var newMatches Matches
+		switch {
+		case limitReached:
+			// only read on to complete the context after the last matches
+		case r.literals != nil:
+			newMatches = r.getLiteralMatches(data, testDataPtr, offset, length, validMatchRange)
+		default:
+			for patternID, re := range r.regexes {
+				tmpMatches := r.getMatches(re, data, testDataPtr, offset, length, validMatchRange, 0, !r.opts.Multiline)
+				if len(tmpMatches) > 0 {
+					for i := range tmpMatches {
+						tmpMatches[i].PatternID = patternID
+					}
+					newMatches = append(newMatches, tmpMatches...)
+				}
+			}
+		}
+
+		if skip == 0 {
+			// blocks not continuing a long line start at the beginning of a line
+			longLineStart = offset
+		}
+		if splitLine || skip > 0 {
+			// matches in the overlap are found with the next window
+			newMatches = matchesWithin(newMatches, offset+int64(skip), offset+int64(validMatchRange), longLineStart)
+		}
+
+		// sort matches and filter duplicates,
+		// for matches at the same position the first pattern is kept
+		if len(newMatches) > 0 {
+			sort.Stable(Matches(newMatches))
+			var validMatch bool
+			prevMatch := lastMatch
+			for i := 0; i < len(newMatches); {
+				validMatch = false
+				if prevMatch == nil {
+					validMatch = true
+				} else {
+					m := newMatches[i]
+					if (!r.opts.Multiline && m.LineEnd > prevMatch.LineEnd) ||
+						(r.opts.Multiline && m.Start >= prevMatch.End) {
+						validMatch = true
+					}
+				}
+				if validMatch {
+					prevMatch = &newMatches[i]
+					i++
+				} else {
+					copy(newMatches[i:], newMatches[i+1:])
+					newMatches = newMatches[0 : len(newMatches)-1]
+				}
+			}
+			lastMatch = &newMatches[len(newMatches)-1]
+		}
+
+		if r.opts.InvertMatch && !limitReached {
+			newMatches = r.invertMatches(data, newMatches, offset, length, validMatchRange, &lastCoveredLineEnd)
+		}
+
+		if !limitReached {
+			for conditionID, condition := range r.conditions {
+				tmpMatches := r.getMatches(condition.regex, data, testDataPtr, offset, length, validMatchRange, conditionID, false)
+				if len(tmpMatches) > 0 {
+					conditionMatches = append(conditionMatches, tmpMatches...)
+				}
+			}
+			if splitLine || skip > 0 {
+				conditionMatches = matchesWithin(conditionMatches, offset+int64(skip), offset+int64(validMatchRange), longLineStart)
+			}
+			if len(conditionMatches) > 0 {
+				sort.Sort(Matches(conditionMatches))
+			}
+		}
+
+		if r.opts.LineNumbers || r.opts.ContextBefore > 0 || r.opts.ContextAfter > 0 || len(r.conditions) > 0 || ranges != nil {
+			linecount = countLines(data, lastConditionMatch, newMatches, conditionMatches, offset, validMatchRange, linecount)
+		}
+		if ranges != nil {
+			newMatches = matchesOnLines(newMatches, ranges)
+		}
+
+		// if a list option is used exit here if possible
+		if len(newMatches) > 0 && r.opts.FileMatchOnly && conditions == nil {
+			r.resultsChan <- &Result{Target: target, Matches: []Match{newMatches[0]}, IsBinary: resultIsBinary}
+			return nil
+		}
+		if conditions == nil {
+			matchCount += int64(len(newMatches))
+		}
+
+		if contextLines != nil {
+			// matches are released once the lines after them are read
+			contextLines.add(data[:validMatchRange], offset, newMatches, isEOF)
+			newMatches = contextLines.release()
+		}
+
+		if conditions != nil {
+			// matches are released once the conditions are decided for them
+			conditions.add(newMatches, conditionMatches, linecount-1, offset+int64(validMatchRange), isEOF)
+			var pending *Match
+			if contextLines != nil {
+				pending = contextLines.first()
+			}
+			newMatches = conditions.release(pending)
+			if len(newMatches) > 0 && r.opts.FileMatchOnly {
+				r.resultsChan <- &Result{Target: target, Matches: []Match{newMatches[0]}, IsBinary: resultIsBinary}
+				return nil
+			}
+			matchCount += int64(len(newMatches))
+		}
+
+		// matches held back for their context or conditions are released
+		// together, they must not exceed the limit
+		if r.opts.Limit != 0 && reportedCount+int64(len(newMatches)) > r.opts.Limit {
+			newMatches = newMatches[:r.opts.Limit-reportedCount]
+		}
+		reportedCount += int64(len(newMatches))
+
+		if len(newMatches) > 0 {
+			if resultStreaming {
+				matchChan <- newMatches
+			} else {
+				matches = append(matches, newMatches...)
+				if len(matches) > r.streamingThreshold && r.streamingAllowed {
+					resultStreaming = true
+					matchChan = make(chan Matches, 16)
+					r.resultsChan <- &Result{Target: target, Matches: matches, Streaming: true, MatchChan: matchChan, IsBinary: resultIsBinary}
+					defer func() {
+						close(matchChan)
+					}()
+				}
+			}
+		}
+
+		if r.opts.Limit != 0 && matchCount >= r.opts.Limit {
+			// with conditions, only released matches are counted
+			if contextLines == nil || contextLines.empty() || conditions != nil || reportedCount >= r.opts.Limit {
+				break
+			}
+			limitReached = true
+		}
+
+		// copy the bytes not processed after the last newline to the beginning of the buffer
+		if lastSeekAmount > 0 && mapped == nil {
+			copy(data[bufferOffset:bufferOffset+lastSeekAmount], data[lastValidMatchRange-lastSeekAmount:lastValidMatchRange])
+			bufferOffset += lastSeekAmount
+		}
+
+		offset += int64(validMatchRange)
+		skip = 0
+		if splitLine {
+			offset -= longLineLookbehind
+			skip = longLineLookbehind
+		}
+	}
+
+	// with conditions, all matches are released at the end of the input,
+	// remaining matches are beyond the limit
+	if contextLines != nil && conditions == nil {
+		remaining := contextLines.flush()
+		if r.opts.Limit != 0 && reportedCount+int64(len(remaining)) > r.opts.Limit {
+			remaining = remaining[:r.opts.Limit-reportedCount]
+		}
+		if len(remaining) > 0 {
+			if resultStreaming {
+				matchChan <- remaining
+			} else {
+				matches = append(matches, remaining...)
+			}
+		}
+	}
+
+	if !resultStreaming {
+		r.resultsChan <- &Result{Target: target, Matches: matches, Streaming: false, IsBinary: resultIsBinary}
+	}
+	return nil
+}
+
+// growBuffer returns a buffer of twice the size of data, but at most max bytes,
+// holding the first n bytes of data.
+func growBuffer(data []byte, n int, max int) []byte {
+	size := 2 * len(data)
+	if size > max {
+		size = max
+	}
+	buffer := make([]byte, size)
+	copy(buffer, data[:n])
+	return buffer
+}
+
+// matchesWithin removes the matches not starting within [from, to)
+// and cuts their lines at from. lineStart is the offset of the line
+// continued at from.
+func matchesWithin(matches Matches, from int64, to int64, lineStart int64) Matches {
+	valid := matches[:0]
+	for _, m := range matches {
+		if m.Start < from || m.Start >= to {
+			continue
+		}
+		if m.LineStart < from {
+			m.Line = m.Line[from-m.LineStart:]
+			m.LineStart = from
+			m.ColumnOffset = from - lineStart
+		}
+		valid = append(valid, m)
+	}
+	return valid
+}
+
+// getMatches gets all matches in the provided data, it is used for normal and condition matches.
+//
+// data contains the original data.
+// testBuffer contains the data to test the regex against (potentially modified, e.g. to support the ignore case option).
+// length contains the length of the provided data.
+// matches are only valid if they start within the validMatchRange.
+// If firstPerLine is set, further matches on the line of a match are skipped,
+// as only the first one is kept when matching line by line.
+func (s *Searcher) getMatches(regex *regexp.Regexp, data []byte, testBuffer []byte, offset int64, length int, validMatchRange int, conditionID int, firstPerLine bool) Matches {
+	var matches Matches
+	if allIndex := regex.FindAllIndex(testBuffer, -1); allIndex != nil {
+		found := len(allIndex)
+		// the end of the line of the last match found
+		lastLineEnd := -1
+		// for _, index := range allindex {
+		for mi := 0; mi < len(allIndex); mi++ {
+			index := allIndex[mi]
+			start := index[0]
+			end := index[1]
+			// skip matches within the line of the last match,
+			// requeued matches may start before the last match on their line
+			if firstPerLine && mi < found && end <= lastLineEnd {
+				continue
+			}
+			// \s always matches newline, leading to incorrect matches in non-multiline mode
+			// analyze match and reject false matches
+			if !s.opts.Multiline {
+				// remove newlines at the beginning of the match
+				for ; start < length && end > start && data[start] == 0x0a; start++ {
+				}
+				// remove newlines at the end of the match
+				for ; end > 0 && end > start && data[end-1] == 0x0a; end-- {
+				}
+				// check if the corrected match is still valid
+				if (start != index[0] || end != index[1]) && !regex.Match(testBuffer[start:end]) {
+					continue
+				}
+				// check if the match contains newlines
+				if bytes.Contains(data[start:end], []byte{0x0a}) {
+					// Rebuild the complete lines to check whether these contain valid matches.
+					// In very rare cases, multiple lines may contain a valid match. As multiple
+					// matches cannot be processed correctly here, requeue them to be processed again.
+					lineStart := start
+					lineEnd := end
+					for lineStart > 0 && data[lineStart-1] != 0x0a {
+						lineStart--
+					}
+					for lineEnd < length && data[lineEnd] != 0x0a {
+						lineEnd++
+					}
+
+					lastStart := lineStart
+					for pos := lastStart + 1; pos < lineEnd; pos++ {
+						if data[pos] == 0x0a || pos == lineEnd-1 {
+							if pos == lineEnd-1 && data[pos] != 0x0a {
+								pos++
+							}
+							if idx := regex.FindIndex(testBuffer[lastStart:pos]); idx != nil {
+								start = lastStart
+								end = pos
+								start = lastStart + idx[0]
+								end = lastStart + idx[1]
+								allIndex = append(allIndex, []int{start, end})
+							}
+							lastStart = pos + 1
+						}
+					}
+					continue
+				}
+			}
+
+			if m, ok := s.newMatch(data, start, end, offset, length, validMatchRange); ok {
+				m.conditionID = conditionID
+				matches = append(matches, m)
+				if mi < found {
+					lastLineEnd = int(m.LineEnd - offset)
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// getLiteralMatches gets all matches of the literal patterns in the provided data.
+// The arguments are the same as for getMatches.
+func (s *Searcher) getLiteralMatches(data []byte, testBuffer []byte, offset int64, length int, validMatchRange int) Matches {
+	var matches Matches
+	for _, index := range s.literals.findAll(testBuffer, !s.opts.Multiline) {
+		if m, ok := s.newMatch(data, index.start, index.end, offset, length, validMatchRange); ok {
+			m.PatternID = index.patternID
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// newMatch builds the match for data[start:end], including its lines.
+// ok is false if the match is not valid within the current block.
+func (s *Searcher) newMatch(data []byte, start int, end int, offset int64, length int, validMatchRange int) (Match, bool) {
+	lineStart := start
+	lineEnd := end
+	if s.opts.Multiline && start >= validMatchRange {
+		return Match{}, false
+	}
+	for lineStart > 0 && data[lineStart-1] != 0x0a {
+		lineStart--
+	}
+	for lineEnd < length && data[lineEnd] != 0x0a {
+		lineEnd++
+	}
+
+	m := Match{
+		Start:     offset + int64(start),
+		End:       offset + int64(end),
+		LineStart: offset + int64(lineStart),
+		LineEnd:   offset + int64(lineEnd),
+		Match:     string(data[start:end]),
+		Line:      string(data[lineStart:lineEnd]),
+	}
+
+	// handle special case where '^' matches after the last newline
+	return m, lineStart != validMatchRange
+}
+
+// countLines counts the linebreaks within the given buffer and calculates the correct line numbers for new matches
+func countLines(data []byte, lastConditionMatch int, matches Matches, conditionMatches Matches, offset int64, validMatchRange int, lineCount int64) int64 {
+	currentMatch := 0
+	currentConditionMatch := lastConditionMatch + 1
+	if currentMatch < len(matches) || currentConditionMatch < len(conditionMatches) {
+		for i := 0; i < validMatchRange; i++ {
+			if data[i] == 0xa {
+				for currentMatch < len(matches) && offset+int64(i) >= matches[currentMatch].LineStart {
+					matches[currentMatch].Lineno = lineCount
+					currentMatch++
+				}
+				for currentConditionMatch < len(conditionMatches) && offset+int64(i) >= conditionMatches[currentConditionMatch].LineStart {
+					conditionMatches[currentConditionMatch].Lineno = lineCount
+					currentConditionMatch++
+				}
+				lineCount++
+			}
+		}
+		// check for matches on last line without newline
+		for currentMatch < len(matches) && offset+int64(validMatchRange) >= matches[currentMatch].LineStart {
+			matches[currentMatch].Lineno = lineCount
+			currentMatch++
+		}
+		for currentConditionMatch < len(conditionMatches) && offset+int64(validMatchRange) >= conditionMatches[currentConditionMatch].LineStart {
+			conditionMatches[currentConditionMatch].Lineno = lineCount
+			currentConditionMatch++
+		}
+	} else {
+		lineCount += int64(countNewlines(data, validMatchRange))
+	}
+	return lineCount
+}
+
+// invertMatches returns the lines within the validMatchRange that are not part of
+// any of the given matches, as matches spanning the whole line.
+// lastCoveredLineEnd holds the end of the last line covered by a match, as
+// multiline matches may cover lines of the following block.
+func (s *Searcher) invertMatches(data []byte, matches Matches, offset int64, length int, validMatchRange int, lastCoveredLineEnd *int64) Matches {
+	var inverted Matches
+	next := 0
+	for lineStart := 0; lineStart < validMatchRange; {
+		lineEnd := length
+		if pos := bytes.IndexByte(data[lineStart:length], '\n'); pos >= 0 {
+			lineEnd = lineStart + pos
+		}
+		for next < len(matches) && matches[next].LineStart <= offset+int64(lineStart) {
+			if matches[next].LineEnd > *lastCoveredLineEnd {
+				*lastCoveredLineEnd = matches[next].LineEnd
+			}
+			next++
+		}
+		if offset+int64(lineStart) > *lastCoveredLineEnd {
+			if m, ok := s.newMatch(data, lineStart, lineEnd, offset, length, validMatchRange); ok {
+				inverted = append(inverted, m)
+			}
+		}
+		lineStart = lineEnd + 1
+	}
+	return inverted
+}
+
+// Submatch describes a capture group of a match.
+type Submatch struct {
+	Group int
+	Name  string
+	// offset of the start of the submatch
+	Start int64
+	// offset of the end of the submatch
+	End  int64
+	Text string
+}
+
+// testString returns the string the patterns are matched against.
+func (s *Searcher) testString(str string) string {
+	if !s.lowercaseInput() {
+		return str
+	}
+	tmp := []byte(str)
+	bytesToLower(tmp, tmp, len(tmp))
+	return string(tmp)
+}
+
+// submatchIndex returns the regex that produced a match and the index of the match
+// and its capture groups within the lines containing it, as returned by
+// regexp.Regexp.FindStringSubmatchIndex for m.Line.
+func (s *Searcher) submatchIndex(m *Match) (*regexp.Regexp, []int) {
+	if m.PatternID >= len(s.regexes) {
+		return nil, nil
+	}
+	re := s.regexes[m.PatternID]
+	relStart := int(m.Start - m.LineStart)
+	relEnd := int(m.End - m.LineStart)
+	for _, index := range re.FindAllStringSubmatchIndex(s.testString(m.Line), -1) {
+		if index[0] == relStart && index[1] == relEnd {
+			return re, index
+		}
+	}
+	return re, nil
+}
+
+// Submatches returns the capture groups of a match by applying the
+// regex that produced the match to the lines containing it again.
+func (s *Searcher) Submatches(m *Match) []Submatch {
+	re, index := s.submatchIndex(m)
+	if index == nil || re.NumSubexp() == 0 {
+		return nil
+	}
+	names := re.SubexpNames()
+	var submatches []Submatch
+	for group := 1; group <= re.NumSubexp(); group++ {
+		start, end := index[2*group], index[2*group+1]
+		if start < 0 {
+			continue
+		}
+		submatches = append(submatches, Submatch{
+			Group: group,
+			Name:  names[group],
+			Start: m.LineStart + int64(start),
+			End:   m.LineStart + int64(end),
+			Text:  m.Line[start:end],
+		})
+	}
+	return submatches
+}
+
+// Expand returns the replacement for a match. Numbered and named capture groups
+// in template are expanded like in regexp.Regexp.Expand.
+func (s *Searcher) Expand(m *Match, template string) string {
+	re, index := s.submatchIndex(m)
+	if re == nil {
+		return ""
+	}
+	if index != nil {
+		return string(re.ExpandString(nil, template, m.Line, index))
+	}
+	// the match cannot be found in its lines, match the text of the match only
+	var res []byte
+	for _, subIndex := range re.FindAllStringSubmatchIndex(s.testString(m.Match), -1) {
+		res = re.ExpandString(res, template, m.Match, subIndex)
+	}
+	return string(res)
+}
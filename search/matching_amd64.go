@@ -13,7 +13,7 @@
 // You should have received a copy of the GNU General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-package main
+package search
 
 func countNewlines(input []byte, length int) int
 func bytesToLower(input []byte, output []byte, length int)
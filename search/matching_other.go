@@ -15,7 +15,7 @@
 
 // +build !amd64
 
-package main
+package search
 
 var LowercaseLookupTable = [256]uint8{
 	0x0, 0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0x8, 0x9, 0xa, 0xb, 0xc, 0xd, 0xe, 0xf,
@@ -0,0 +1,62 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSplitLongLines(t *testing.T) {
+	long := strings.Repeat("y", 20000) + "needlez" + strings.Repeat("y", 20000)
+	data := "needlez\n" + long + "\nshort\n" + long
+	s, err := New(Options{Patterns: []string{"needlez"}, LineNumbers: true, BlockSize: 8192, SplitLongLines: true, StreamingThreshold: -1})
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	var matches Matches
+	err = s.SearchBytes(context.Background(), []byte(data), "test", func(result *Result) {
+		matches = append(matches, result.Matches...)
+	})
+	if err != nil {
+		t.Fatalf("SearchBytes: %s", err)
+	}
+	want := []struct {
+		lineno int64
+		column int64
+	}{{1, 0}, {2, 20000}, {4, 20000}}
+	if len(matches) != len(want) {
+		t.Fatalf("found %d matches, want %d", len(matches), len(want))
+	}
+	for i, m := range matches {
+		column := m.Start - m.LineStart + m.ColumnOffset
+		if m.Lineno != want[i].lineno || column != want[i].column {
+			t.Errorf("match %d on line %d, column %d, want line %d, column %d", i, m.Lineno, column, want[i].lineno, want[i].column)
+		}
+	}
+
+	// invert match and context need complete lines
+	for _, opts := range []Options{
+		{Patterns: []string{"needlez"}, BlockSize: 8192, SplitLongLines: true, InvertMatch: true},
+		{Patterns: []string{"needlez"}, BlockSize: 8192, SplitLongLines: true, ContextBefore: 1},
+		{Patterns: []string{"needlez"}, BlockSize: 8192, SplitLongLines: true, ContextAfter: 1},
+	} {
+		if _, err := New(opts); err == nil {
+			t.Errorf("New(%+v) succeeded, want error", opts)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"bytes"
+	"errors"
+	"runtime/debug"
+)
+
+// ErrMappedFileFault is reported (wrapped in a TargetError) if a memory-mapped
+// file cannot be accessed anymore, e.g. because it was truncated while being searched.
+var ErrMappedFileFault = errors.New("mapped file cannot be accessed, it was possibly truncated")
+
+// mappedReader provides the content of a memory-mapped file. processReader
+// searches the mapped data in place instead of reading it into its buffer.
+type mappedReader struct {
+	*bytes.Reader
+	data []byte
+}
+
+func newMappedReader(data []byte) *mappedReader {
+	return &mappedReader{Reader: bytes.NewReader(data), data: data}
+}
+
+// guardMapped calls fn and returns ErrMappedFileFault if fn faults accessing
+// mapped memory, which would otherwise crash the program.
+func guardMapped(fn func() error) (err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(interface{ Addr() uintptr }); ok {
+				err = ErrMappedFileFault
+				return
+			}
+			panic(e)
+		}
+	}()
+	return fn()
+}
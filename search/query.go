@@ -0,0 +1,377 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Query is a compiled query expression. Patterns are the search patterns,
+// Expr combines the Conditions that have to be fulfilled for a match.
+//
+// A query combines patterns with 'and', 'or', 'not' and parentheses, e.g.
+//
+//	foo and not (bar or file:baz) and window:3:qux
+//
+// A pattern can be prefixed with a scope: 'line:' (default) requires the
+// pattern to match on the line of the match, 'file:' anywhere in the file
+// and 'window:N:' within N lines around the match. Patterns containing
+// whitespace, parentheses or quotes are written in double quotes (with \"
+// for a quote) or single quotes (taken literally).
+type Query struct {
+	Patterns   []string
+	Conditions []Condition
+	Expr       *ConditionExpr
+}
+
+// queryScope is the range a query term has to match in.
+type queryScope int
+
+const (
+	scopeLine queryScope = iota
+	scopeFile
+	scopeWindow
+)
+
+type queryTokenType int
+
+const (
+	tokenEOF queryTokenType = iota
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenLeftParen
+	tokenRightParen
+	tokenTerm
+)
+
+var queryKeywords = map[string]queryTokenType{
+	"and": tokenAnd,
+	"or":  tokenOr,
+	"not": tokenNot,
+}
+
+type queryToken struct {
+	typ queryTokenType
+	// position in the query (1-based)
+	pos     int
+	text    string
+	scope   queryScope
+	within  int64
+	pattern string
+}
+
+// queryParser is a recursive descent parser for query expressions.
+type queryParser struct {
+	query string
+	pos   int
+	token queryToken
+	q     *Query
+}
+
+// ParseQuery parses a query expression (see Query).
+func ParseQuery(query string) (*Query, error) {
+	p := &queryParser{query: query, q: &Query{}}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.token.typ == tokenEOF {
+		return nil, errors.New("empty query")
+	}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.token.typ != tokenEOF {
+		return nil, p.errorf("unexpected %s, expected 'and', 'or' or end of query", p.token.describe())
+	}
+	p.q.Expr = expr
+
+	patterns, bounded := p.matchPatterns(expr)
+	if !bounded {
+		return nil, errors.New("query needs a line pattern that is not negated and must be found on every reported line")
+	}
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		if !seen[pattern] {
+			seen[pattern] = true
+			p.q.Patterns = append(p.q.Patterns, pattern)
+		}
+	}
+	return p.q, nil
+}
+
+// matchPatterns returns the patterns to search for, so every line fulfilling
+// expr matches one of them. bounded is false if there is no such set of patterns.
+func (p *queryParser) matchPatterns(expr *ConditionExpr) (patterns []string, bounded bool) {
+	switch expr.Op {
+	case ExprCondition:
+		c := p.q.Conditions[expr.Condition]
+		if c.Type == ConditionSurrounded && c.Within == 0 {
+			return []string{c.Pattern}, true
+		}
+	case ExprAnd:
+		for _, operand := range expr.Operands {
+			if patterns, bounded := p.matchPatterns(operand); bounded {
+				return patterns, true
+			}
+		}
+	case ExprOr:
+		for _, operand := range expr.Operands {
+			operandPatterns, bounded := p.matchPatterns(operand)
+			if !bounded {
+				return nil, false
+			}
+			patterns = append(patterns, operandPatterns...)
+		}
+		return patterns, true
+	}
+	return nil, false
+}
+
+func (p *queryParser) parseOr() (*ConditionExpr, error) {
+	expr, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	if p.token.typ != tokenOr {
+		return expr, nil
+	}
+	or := &ConditionExpr{Op: ExprOr, Operands: []*ConditionExpr{expr}}
+	for p.token.typ == tokenOr {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		or.Operands = append(or.Operands, expr)
+	}
+	return or, nil
+}
+
+func (p *queryParser) parseAnd() (*ConditionExpr, error) {
+	expr, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	if p.token.typ != tokenAnd {
+		return expr, nil
+	}
+	and := &ConditionExpr{Op: ExprAnd, Operands: []*ConditionExpr{expr}}
+	for p.token.typ == tokenAnd {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		and.Operands = append(and.Operands, expr)
+	}
+	return and, nil
+}
+
+func (p *queryParser) parseNot() (*ConditionExpr, error) {
+	if p.token.typ != tokenNot {
+		return p.parsePrimary()
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return &ConditionExpr{Op: ExprNot, Operands: []*ConditionExpr{expr}}, nil
+}
+
+func (p *queryParser) parsePrimary() (*ConditionExpr, error) {
+	switch p.token.typ {
+	case tokenLeftParen:
+		start := p.token.pos
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.token.typ != tokenRightParen {
+			return nil, p.errorf("unexpected %s, expected ')' to close '(' at position %d", p.token.describe(), start)
+		}
+		return expr, p.next()
+	case tokenTerm:
+		c := Condition{Pattern: p.token.pattern}
+		switch p.token.scope {
+		case scopeLine:
+			c.Type = ConditionSurrounded
+		case scopeFile:
+			c.Type = ConditionFileMatches
+		case scopeWindow:
+			c.Type = ConditionSurrounded
+			c.Within = p.token.within
+		}
+		p.q.Conditions = append(p.q.Conditions, c)
+		return &ConditionExpr{Op: ExprCondition, Condition: len(p.q.Conditions) - 1}, p.next()
+	}
+	return nil, p.errorf("unexpected %s, expected a pattern, 'not' or '('", p.token.describe())
+}
+
+// next reads the next token from the query.
+func (p *queryParser) next() error {
+	for p.pos < len(p.query) && isQuerySpace(p.query[p.pos]) {
+		p.pos++
+	}
+	p.token = queryToken{pos: p.pos + 1}
+	if p.pos == len(p.query) {
+		p.token.typ = tokenEOF
+		return nil
+	}
+
+	switch p.query[p.pos] {
+	case '(':
+		p.pos++
+		p.token.typ, p.token.text = tokenLeftParen, "("
+		return nil
+	case ')':
+		p.pos++
+		p.token.typ, p.token.text = tokenRightParen, ")"
+		return nil
+	}
+
+	start := p.pos
+	if p.query[p.pos] != '"' && p.query[p.pos] != '\'' {
+		word := p.query[p.pos:p.wordEnd()]
+		if typ, ok := queryKeywords[strings.ToLower(word)]; ok {
+			p.pos += len(word)
+			p.token.typ, p.token.text = typ, word
+			return nil
+		}
+		if err := p.readScope(); err != nil {
+			return err
+		}
+	}
+
+	p.token.typ = tokenTerm
+	pattern, err := p.readPattern()
+	if err != nil {
+		return err
+	}
+	if pattern == "" {
+		return p.errorf("empty pattern")
+	}
+	p.token.pattern = pattern
+	p.token.text = p.query[start:p.pos]
+	return nil
+}
+
+// readScope reads an optional scope prefix of a term.
+func (p *queryParser) readScope() error {
+	rest := p.query[p.pos:]
+	switch {
+	case hasPrefixFold(rest, "line:"):
+		p.token.scope = scopeLine
+		p.pos += len("line:")
+	case hasPrefixFold(rest, "file:"):
+		p.token.scope = scopeFile
+		p.pos += len("file:")
+	case hasPrefixFold(rest, "window:"):
+		p.token.scope = scopeWindow
+		p.pos += len("window:")
+		end := strings.IndexByte(p.query[p.pos:], ':')
+		if end < 0 {
+			return p.errorf("wrong format for window scope, expected window:NUM:PATTERN")
+		}
+		within, err := strconv.ParseInt(p.query[p.pos:p.pos+end], 10, 64)
+		if err != nil || within < 0 {
+			return p.errorf("cannot parse window scope: '%s' is not a number >= 0", p.query[p.pos:p.pos+end])
+		}
+		p.token.within = within
+		p.pos += end + 1
+	}
+	return nil
+}
+
+// readPattern reads a quoted or unquoted pattern.
+func (p *queryParser) readPattern() (string, error) {
+	if p.pos == len(p.query) {
+		return "", nil
+	}
+	switch quote := p.query[p.pos]; quote {
+	case '\'':
+		end := strings.IndexByte(p.query[p.pos+1:], '\'')
+		if end < 0 {
+			return "", p.errorf("missing closing quote (')")
+		}
+		pattern := p.query[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return pattern, nil
+	case '"':
+		var pattern []byte
+		for i := p.pos + 1; i < len(p.query); i++ {
+			switch {
+			case p.query[i] == '"':
+				p.pos = i + 1
+				return string(pattern), nil
+			case p.query[i] == '\\' && i+1 < len(p.query) && p.query[i+1] == '"':
+				pattern = append(pattern, '"')
+				i++
+			default:
+				pattern = append(pattern, p.query[i])
+			}
+		}
+		return "", p.errorf("missing closing quote (\")")
+	}
+	end := p.wordEnd()
+	pattern := p.query[p.pos:end]
+	p.pos = end
+	return pattern, nil
+}
+
+// wordEnd returns the end of the unquoted word at the current position.
+func (p *queryParser) wordEnd() int {
+	end := p.pos
+	for end < len(p.query) && !isQuerySpace(p.query[end]) && p.query[end] != '(' && p.query[end] != ')' {
+		end++
+	}
+	return end
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at position %d: %s", p.token.pos, fmt.Sprintf(format, args...))
+}
+
+// describe returns a description of the token for error messages.
+func (t queryToken) describe() string {
+	if t.typ == tokenEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+func isQuerySpace(c byte) bool {
+	return c < 0x80 && unicode.IsSpace(rune(c))
+}
+
+func hasPrefixFold(s string, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
@@ -0,0 +1,100 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Edit describes the replacement of the bytes from Start to End of a target.
+type Edit struct {
+	Start int64
+	End   int64
+	Text  string
+}
+
+// Edits returns the edits replacing the matches by the expanded template, sorted
+// by offset. Numbered and named capture groups are expanded like in Expand.
+// In single line mode, only the first match of a line is reported, so all
+// occurrences of the patterns on the lines of the matches are replaced.
+func (s *Searcher) Edits(matches Matches, template string) []Edit {
+	var edits []Edit
+	for i := range matches {
+		m := &matches[i]
+		if s.opts.Multiline {
+			if re, index := s.submatchIndex(m); index != nil {
+				edits = append(edits, Edit{
+					Start: m.Start,
+					End:   m.End,
+					Text:  string(re.ExpandString(nil, template, m.Line, index)),
+				})
+			}
+			continue
+		}
+		edits = append(edits, s.lineEdits(m, template)...)
+	}
+	return edits
+}
+
+// lineEdits returns the edits for all non-overlapping occurrences of the patterns
+// on the line of a match. For occurrences at the same position the first pattern is used.
+func (s *Searcher) lineEdits(m *Match, template string) []Edit {
+	type occurrence struct {
+		patternID int
+		index     []int
+	}
+	var occurrences []occurrence
+	testLine := s.testString(m.Line)
+	for patternID, re := range s.regexes {
+		for _, index := range re.FindAllStringSubmatchIndex(testLine, -1) {
+			occurrences = append(occurrences, occurrence{patternID, index})
+		}
+	}
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		return occurrences[i].index[0] < occurrences[j].index[0]
+	})
+
+	var edits []Edit
+	lastStart, lastEnd := -1, 0
+	for _, o := range occurrences {
+		start, end := o.index[0], o.index[1]
+		if start < lastEnd || start == lastStart {
+			continue
+		}
+		edits = append(edits, Edit{
+			Start: m.LineStart + int64(start),
+			End:   m.LineStart + int64(end),
+			Text:  string(s.regexes[o.patternID].ExpandString(nil, template, m.Line, o.index)),
+		})
+		lastStart, lastEnd = start, end
+	}
+	return edits
+}
+
+// ApplyEdits returns a copy of data with the edits applied.
+// The edits must be sorted and must not overlap.
+func ApplyEdits(data []byte, edits []Edit) []byte {
+	var buf bytes.Buffer
+	var pos int64
+	for _, e := range edits {
+		buf.Write(data[pos:e.Start])
+		buf.WriteString(e.Text)
+		pos = e.End
+	}
+	buf.Write(data[pos:])
+	return buf.Bytes()
+}
@@ -0,0 +1,71 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLineEdits(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		line     string
+		template string
+		want     []Edit
+	}{
+		{[]string{"foo"}, "foo bar foo\n", "x", []Edit{{10, 13, "x"}, {18, 21, "x"}}},
+		{[]string{`(\w+)=(\w+)`}, "a=1 b=2\n", "$2=$1", []Edit{{10, 13, "1=a"}, {14, 17, "2=b"}}},
+		{[]string{`(?P<key>\w+):`}, "name: x\n", "${key} =", []Edit{{10, 15, "name ="}}},
+		// occurrences at the same position are replaced using the first pattern
+		{[]string{"ab", "abc"}, "abc\n", "x", []Edit{{10, 12, "x"}}},
+		{[]string{"abc", "ab"}, "abc\n", "x", []Edit{{10, 13, "x"}}},
+		// overlapping occurrences of later patterns are skipped
+		{[]string{"bc", "abcd"}, "abcd bc\n", "x", []Edit{{10, 14, "x"}, {15, 17, "x"}}},
+		{[]string{"a*"}, "baa\n", "x", []Edit{{10, 10, "x"}, {11, 13, "x"}, {14, 14, "x"}}},
+	}
+	for _, test := range tests {
+		s, err := New(Options{Patterns: test.patterns})
+		if err != nil {
+			t.Fatalf("New(%q): %s", test.patterns, err)
+		}
+		m := Match{LineStart: 10, LineEnd: 10 + int64(len(test.line)), Line: test.line}
+		got := s.lineEdits(&m, test.template)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("lineEdits(%q, %q, %q) = %v, want %v", test.patterns, test.line, test.template, got, test.want)
+		}
+	}
+}
+
+func TestApplyEdits(t *testing.T) {
+	tests := []struct {
+		data  string
+		edits []Edit
+		want  string
+	}{
+		{"hello world", nil, "hello world"},
+		{"hello world", []Edit{{0, 5, "bye"}}, "bye world"},
+		{"hello world", []Edit{{6, 11, "there"}}, "hello there"},
+		{"abc", []Edit{{0, 0, ">"}, {1, 2, ""}, {3, 3, "<"}}, ">ac<"},
+		{"a\nb\nc\n", []Edit{{0, 2, ""}, {4, 6, "C\nD\n"}}, "b\nC\nD\n"},
+	}
+	for _, test := range tests {
+		got := string(ApplyEdits([]byte(test.data), test.edits))
+		if got != test.want {
+			t.Errorf("ApplyEdits(%q, %v) = %q, want %q", test.data, test.edits, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svent/sift/git"
+)
+
+// revisionBlob is a file of a git revision to search.
+type revisionBlob struct {
+	repo *git.Repository
+	hash git.Hash
+	// the target name, "REV:PATH"
+	name string
+}
+
+// revisionWalk holds the state of walking the trees of the revisions
+// of a target.
+type revisionWalk struct {
+	repo  *git.Repository
+	label string
+	// trees already walked at the same path
+	seenTrees map[string]bool
+}
+
+// revisionSearch checks whether git revisions are searched instead of the
+// files of the targets.
+func (s *Searcher) revisionSearch() bool {
+	return len(s.opts.Revisions) > 0 || s.opts.AllRevisions
+}
+
+// processRevisionTargets searches the files of the targets in the git
+// revisions selected by the options. Files with identical content are only
+// searched once, in the first revision found containing them.
+func (r *searchRun) processRevisionTargets(targets []string) {
+	defer r.targetsWaitGroup.Done()
+	blobs := make(chan revisionBlob, 256)
+	var wg sync.WaitGroup
+	for i := 0; i < r.opts.Cores; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.processRevisionBlobs(blobs)
+		}()
+	}
+
+	seenBlobs := map[git.Hash]bool{}
+	for _, target := range targets {
+		if r.ctx.Err() != nil {
+			break
+		}
+		r.processRevisionTarget(target, seenBlobs, blobs)
+	}
+	close(blobs)
+	wg.Wait()
+}
+
+// processRevisionTarget walks the trees of the revisions for a target and
+// sends the selected files on blobs.
+func (r *searchRun) processRevisionTarget(target string, seenBlobs map[git.Hash]bool, blobs chan<- revisionBlob) {
+	repo, err := git.Find(target)
+	if err != nil {
+		r.reportError("cannot open git repository", target, err)
+		return
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		r.reportError("cannot open git repository", target, err)
+		return
+	}
+	prefix, err := filepath.Rel(repo.Root, absTarget)
+	if err != nil {
+		r.reportError("cannot open git repository", target, err)
+		return
+	}
+	var components []string
+	if prefix != "." {
+		components = strings.Split(filepath.ToSlash(prefix), "/")
+	}
+
+	// revisions given by name are printed as given, others by object name
+	var revisions []git.Hash
+	var labels []string
+	for _, rev := range r.opts.Revisions {
+		hash, err := repo.Resolve(rev)
+		if err != nil {
+			r.reportError("cannot resolve revision", rev, err)
+			continue
+		}
+		revisions = append(revisions, hash)
+		labels = append(labels, rev)
+	}
+	if r.opts.AllRevisions {
+		refs, err := repo.Refs()
+		if err == nil {
+			var commits []git.Hash
+			if commits, err = repo.Commits(refs); err == nil {
+				for _, commit := range commits {
+					revisions = append(revisions, commit)
+					labels = append(labels, string(commit))
+				}
+			}
+		}
+		if err != nil {
+			r.reportError("cannot read git history", target, err)
+		}
+	}
+
+	walk := &revisionWalk{repo: repo, seenTrees: map[string]bool{}}
+	for i, rev := range revisions {
+		if r.ctx.Err() != nil {
+			return
+		}
+		tree, err := repo.Tree(rev)
+		if err != nil {
+			r.reportError("cannot read revision", labels[i], err)
+			continue
+		}
+		walk.label = labels[i]
+
+		// find the tree or file of the target within the revision
+		entry := git.TreeEntry{Mode: git.ModeDir, Hash: tree}
+		for _, name := range components {
+			entries, err := repo.ReadTree(entry.Hash)
+			if err != nil {
+				r.reportError("cannot read revision", walk.label, err)
+				break
+			}
+			entry = git.TreeEntry{}
+			for _, e := range entries {
+				if e.Name == name {
+					entry = e
+					break
+				}
+			}
+			if entry.Mode&git.ModeTypeMask != git.ModeDir {
+				break
+			}
+		}
+		switch entry.Mode & git.ModeTypeMask {
+		case git.ModeDir:
+			r.walkRevisionTree(walk, entry.Hash, filepath.Clean(target), seenBlobs, blobs)
+		case git.ModeRegular:
+			// files given as target are always selected
+			if !seenBlobs[entry.Hash] {
+				seenBlobs[entry.Hash] = true
+				r.sendRevisionBlob(blobs, revisionBlob{repo, entry.Hash, walk.label + ":" + filepath.Clean(target)})
+			}
+		}
+	}
+}
+
+// walkRevisionTree applies the directory and file options to the entries of
+// a tree and sends the selected files on blobs. Symlinks and submodules are
+// skipped, the shebang of files is not checked for file types.
+func (r *searchRun) walkRevisionTree(walk *revisionWalk, tree git.Hash, dirname string,
+	seenBlobs map[git.Hash]bool, blobs chan<- revisionBlob) {
+	key := dirname + "\x00" + string(tree)
+	if walk.seenTrees[key] || r.ctx.Err() != nil {
+		return
+	}
+	walk.seenTrees[key] = true
+	entries, err := walk.repo.ReadTree(tree)
+	if err != nil {
+		r.reportError("cannot read revision", walk.label+":"+dirname, err)
+		return
+	}
+	for _, e := range entries {
+		path := filepath.Join(dirname, e.Name)
+		var decision filterDecision
+		switch e.Mode & git.ModeTypeMask {
+		case git.ModeDir:
+			decision = r.dirFilter(e.Name)
+		case git.ModeRegular:
+			decision = r.fileFilter(e.Name, path, false)
+		default:
+			decision = filterDecision{value: "not a regular file"}
+		}
+		selected := decision == filterDecision{}
+		if r.opts.SelectionHandler != nil {
+			sel := r.selection(path, treeEntryInfo{e}, selected, decision, nil)
+			sel.Path = walk.label + ":" + path
+			r.opts.SelectionHandler(sel)
+		}
+		switch {
+		case !selected:
+		case e.Mode&git.ModeTypeMask == git.ModeDir:
+			r.walkRevisionTree(walk, e.Hash, path, seenBlobs, blobs)
+		case !seenBlobs[e.Hash]:
+			seenBlobs[e.Hash] = true
+			r.sendRevisionBlob(blobs, revisionBlob{walk.repo, e.Hash, walk.label + ":" + path})
+		}
+	}
+}
+
+// sendRevisionBlob sends a file on blobs unless the search is aborted.
+func (r *searchRun) sendRevisionBlob(blobs chan<- revisionBlob, blob revisionBlob) {
+	select {
+	case blobs <- blob:
+	case <-r.ctx.Done():
+	}
+}
+
+// processRevisionBlobs reads the files sent on blobs and searches them.
+func (r *searchRun) processRevisionBlobs(blobs <-chan revisionBlob) {
+	dataBuffer := make([]byte, r.blockSize)
+	testBuffer := make([]byte, r.blockSize)
+	for blob := range blobs {
+		if r.ctx.Err() != nil {
+			// the search was aborted, only drain the channel
+			continue
+		}
+		archive := r.opts.Archives && archiveKindOf(blob.name) != archiveNone
+		if r.opts.TargetsOnly && !archive {
+			r.resultsChan <- &Result{Target: blob.name}
+			continue
+		}
+		typ, data, err := blob.repo.ReadObject(blob.hash)
+		if err == nil && typ != git.ObjectBlob {
+			err = fmt.Errorf("object %s is a %s", blob.hash, typ)
+		}
+		if err != nil {
+			r.reportError("cannot read file", blob.name, err)
+			continue
+		}
+
+		// archives are searched like archive members, otherwise the
+		// depth prevents treating the file as archive
+		depth := 0
+		if !archive {
+			depth = r.archiveDepth
+		}
+		ctx, cancel := r.fileContext()
+		err = r.processArchiveMember(ctx, bytes.NewReader(data), blob.name, depth, dataBuffer, testBuffer)
+		cancel()
+		if err != nil {
+			r.reportTargetError("cannot process data from file", blob.name, err)
+		}
+	}
+}
+
+// treeEntryInfo describes a tree entry as os.FileInfo.
+type treeEntryInfo struct {
+	entry git.TreeEntry
+}
+
+func (fi treeEntryInfo) Name() string       { return fi.entry.Name }
+func (fi treeEntryInfo) Size() int64        { return 0 }
+func (fi treeEntryInfo) ModTime() time.Time { return time.Time{} }
+func (fi treeEntryInfo) IsDir() bool        { return fi.entry.Mode&git.ModeTypeMask == git.ModeDir }
+func (fi treeEntryInfo) Sys() interface{}   { return nil }
+
+func (fi treeEntryInfo) Mode() os.FileMode {
+	switch fi.entry.Mode & git.ModeTypeMask {
+	case git.ModeDir:
+		return os.ModeDir | 0755
+	case git.ModeSymlink:
+		return os.ModeSymlink | 0777
+	case git.ModeGitlink:
+		return os.ModeIrregular
+	}
+	return os.FileMode(fi.entry.Mode & 0777)
+}
@@ -0,0 +1,746 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+/*
+Package search provides the search engine of sift.
+
+A Searcher is created from an Options struct and can search files,
+directories, network connections, io.Readers and byte slices.
+Results are passed to a ResultHandler, one Result per processed target.
+
+All state of a search is kept per call, so a single Searcher can be
+used for multiple concurrent searches and multiple Searchers can be
+used in the same process.
+
+Example:
+
+	s, err := search.New(search.Options{
+		Patterns:     []string{`func \w+`},
+		LineNumbers:  true,
+		Recursive:    true,
+		FileTypes:    search.DefaultFileTypes(),
+		IncludeTypes: []string{"go"},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = s.Search(context.Background(), []string{"."}, func(result *search.Result) {
+		for _, m := range result.Matches {
+			fmt.Printf("%s:%d:%s\n", result.Target, m.Lineno, m.Line)
+		}
+	})
+*/
+package search
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/svent/sift/git"
+	"github.com/svent/sift/gitignore"
+	"github.com/svent/sift/index"
+)
+
+const (
+	// DefaultBlockSize is the default size of the input buffer
+	DefaultBlockSize = 256 * 1024
+	// DefaultStreamingThreshold is the default number of matches per target
+	// after which matches are streamed through Result.MatchChan
+	DefaultStreamingThreshold = 1 << 16
+	// InputMultilineWindow is the size of the sliding window for multiline matching
+	InputMultilineWindow = 32 * 1024
+	// MultilinePipeTimeout is the timeout for reading and matching input
+	// from STDIN/network in multiline mode
+	MultilinePipeTimeout = 1000 * time.Millisecond
+	// MultilinePipeChunkTimeout is the timeout to consider last input from STDIN/network
+	// as a complete chunk for multiline matching
+	MultilinePipeChunkTimeout = 150 * time.Millisecond
+	// MaxDirRecursionRoutines is the maximum number of parallel routines used
+	// to recurse into directories
+	MaxDirRecursionRoutines = 3
+	// DefaultArchiveDepth is the default maximum nesting depth of archives
+	DefaultArchiveDepth = 3
+	// MaxNestedZipSize is the maximum size of zip archives nested in other
+	// archives, which are read into memory for random access
+	MaxNestedZipSize = 256 * 1024 * 1024
+	// LongLineOverlap is the number of bytes searched again at the beginning of
+	// the next window if lines exceeding the input buffer are split into windows
+	LongLineOverlap = 4 * 1024
+	// longLineLookbehind is the number of bytes kept before the next window of
+	// a long line, so that assertions at its beginning see the preceding rune
+	longLineLookbehind = utf8.UTFMax
+	// DefaultSegmentSize is the default size of the segments of large files
+	// that are searched in parallel
+	DefaultSegmentSize = 64 * 1024 * 1024
+)
+
+var (
+	// ErrLineTooLong is reported (wrapped in a TargetError) if a target
+	// contains a line that does not fit into the input buffer, even after
+	// growing it up to Options.MaxLineLength.
+	ErrLineTooLong = errors.New("line too long")
+	// ErrFileTimeout is reported (wrapped in a TargetError) if processing
+	// a target takes longer than Options.FileTimeout.
+	ErrFileTimeout = errors.New("file timeout exceeded")
+)
+
+var netTcpRegex = regexp.MustCompile(`^(tcp[46]?)://(.*:\d+)$`)
+
+type ConditionType int
+
+const (
+	ConditionPreceded ConditionType = iota
+	ConditionFollowed
+	ConditionSurrounded
+	ConditionFileMatches
+	ConditionLineMatches
+	ConditionRangeMatches
+	ConditionFileMatchesAtLeast
+	ConditionFileMatchesAtMost
+	ConditionPrecededBytes
+	ConditionFollowedBytes
+	ConditionSurroundedBytes
+)
+
+// Condition restricts the matches reported for a target.
+type Condition struct {
+	// Pattern is the regular expression of the condition.
+	// It is prepared like the search patterns (ignore case, literal, ...).
+	// Preceded/followed/surrounded conditions can reference named groups of
+	// the search patterns with (?P=name), which then match the text captured
+	// by each match.
+	Pattern string
+	Type    ConditionType
+	// Within is the maximum distance in lines for preceded/followed/surrounded
+	// conditions (-1 = no limit), or in bytes for the byte distance variants.
+	Within int64
+	// LineRangeStart and LineRangeEnd select the lines for line and range conditions.
+	LineRangeStart int64
+	LineRangeEnd   int64
+	// Count is the number of matches of Pattern required in the file by
+	// at least/at most conditions.
+	Count   int64
+	Negated bool
+}
+
+// ExprOp is the operator of a ConditionExpr.
+type ExprOp int
+
+const (
+	// ExprCondition is a single condition.
+	ExprCondition ExprOp = iota
+	ExprAnd
+	ExprOr
+	ExprNot
+)
+
+// ConditionExpr combines conditions with boolean operators.
+type ConditionExpr struct {
+	Op ExprOp
+	// Condition is the index into Options.Conditions for ExprCondition.
+	Condition int
+	Operands  []*ConditionExpr
+}
+
+// condition is a Condition with a compiled regex.
+type condition struct {
+	Condition
+	regex *regexp.Regexp
+	// references to named groups of the search patterns
+	groupRefs []groupRef
+}
+
+type FileType struct {
+	Patterns     []string
+	ShebangRegex *regexp.Regexp
+}
+
+// Options configures a Searcher.
+type Options struct {
+	// Patterns contains the regular expressions to search for.
+	Patterns   []string
+	Conditions []Condition
+	// ConditionExpr combines the conditions. If it is nil, a match is only
+	// reported if all conditions are fulfilled.
+	ConditionExpr *ConditionExpr
+
+	// IgnoreCase enables case insensitive matching. By default, the input is
+	// lowercased byte-wise, which only covers ASCII letters. Unicode case folding
+	// is used if UnicodeCase is set or any pattern contains non-ASCII characters.
+	IgnoreCase bool
+	// UnicodeCase enables Unicode case folding for case insensitive matching.
+	// It is slower than the default ASCII mode, but also handles non-ASCII letters
+	// and keeps character classes like \W or [A-Z] intact. Only simple case
+	// folding is supported, e.g. 'ß' does not match "SS".
+	UnicodeCase bool
+	Literal     bool
+	WordRegexp  bool
+	Multiline   bool
+	InvertMatch bool
+
+	// BinarySkip skips files that seem to be binary.
+	BinarySkip    bool
+	ContextBefore int
+	ContextAfter  int
+	// Limit is the maximum number of matches per target (0 = no limit).
+	Limit int64
+	// LineNumbers enables the calculation of line numbers.
+	LineNumbers bool
+	// FileMatchOnly stops processing a target after the first match,
+	// e.g. to list matching files.
+	FileMatchOnly bool
+	// BlockSize is the size of the input buffer (0 = DefaultBlockSize).
+	BlockSize int
+	// MaxLineLength is the size the input buffers grow up to for targets with
+	// lines longer than BlockSize (0 = no growth). The buffers only grow for the
+	// target containing such lines. For memory-mapped files, it limits the length
+	// of lines, which is not limited otherwise.
+	MaxLineLength int
+	// SplitLongLines searches lines that do not fit into the input buffer in
+	// windows overlapping by LongLineOverlap bytes instead of reporting ErrLineTooLong.
+	// Offsets and line numbers of matches are correct, but Match.Line only holds
+	// the part of the line within a window and matches longer than LongLineOverlap
+	// may be missed at the border of two windows. It cannot be combined with
+	// InvertMatch or context lines, which need complete lines.
+	SplitLongLines bool
+	// StreamingThreshold is the number of matches per target after which matches
+	// are streamed through Result.MatchChan. A negative value disables streaming.
+	// With conditions, matches are streamed once the conditions are decided for them.
+	StreamingThreshold int
+	// Cores is the number of targets processed in parallel (0 = all CPUs).
+	// Large files are split into segments searched by up to Cores workers.
+	Cores int
+	// SegmentSize is the size of the segments large regular files are split into
+	// (0 = DefaultSegmentSize, negative = never split files). Files are only split
+	// if they contain at least two segments and neither Multiline, context lines,
+	// conditions nor ChangedLines are used.
+	SegmentSize int64
+	// FileTimeout limits the time spent on a single target (0 = no limit).
+	// No results are reported for targets exceeding it, apart from matches
+	// already streamed.
+	FileTimeout time.Duration
+	// Zip enables searching the content of compressed files. The format is detected
+	// by file extension and signature, see RegisterDecompressor.
+	Zip bool
+	// Archives enables searching the members of zip and tar archives.
+	// The file options are applied to the member names.
+	Archives bool
+	// ArchiveDepth is the maximum nesting depth of archives (0 = DefaultArchiveDepth).
+	// Nested archives exceeding the depth are searched like normal files.
+	ArchiveDepth int
+	// Mmap enables searching regular files in place by mapping them into memory
+	// (only supported on Linux, other files are read). Lines of mapped files are
+	// not limited by the block size, only by MaxLineLength if it is set.
+	Mmap bool
+	// TargetsOnly only selects targets, they are reported without being searched.
+	TargetsOnly bool
+	// Index is a trigram index used to skip files that cannot contain a match.
+	// Files not covered by the index or modified since it was built are searched.
+	// The index is not used with InvertMatch, Zip or Archives.
+	Index *index.Index
+
+	Recursive      bool
+	FollowSymlinks bool
+	Git            bool
+	// GitTracked selects the files of directory targets from the index of
+	// the git repository containing them instead of recursing into the
+	// directories. The directory and file options are applied, ignore
+	// files are not, as tracked files are never ignored.
+	GitTracked bool
+	// GitUntracked additionally selects the files of directory targets that
+	// are neither tracked nor ignored. It implies GitTracked.
+	GitUntracked bool
+	// Revisions are git revisions (e.g. "HEAD~2" or "v1.0") whose files are
+	// searched instead of the files of the targets. Targets are paths within
+	// a git repository, which do not need to exist in the work tree. The
+	// targets of results are named "REV:PATH".
+	Revisions []string
+	// AllRevisions searches the files of all commits reachable from the refs,
+	// named by the object name of the commit. Like with Revisions, files with
+	// the same content are only searched once.
+	AllRevisions bool
+	// ChangedSince selects the files of the targets whose content in the
+	// work tree differs from the git revision ChangedSince, e.g. "HEAD" for
+	// the files modified or added to the index since the last commit.
+	// Untracked files are not selected. The directory and file options are
+	// applied as with GitTracked.
+	ChangedSince string
+	// ChangedLines restricts the matches in files selected by ChangedSince to
+	// matches on lines added or modified since the revision. Files not present
+	// in the revision are not restricted.
+	ChangedLines      bool
+	IncludeDirs       []string
+	ExcludeDirs       []string
+	IncludeFiles      []string
+	ExcludeFiles      []string
+	IncludeExtensions []string
+	ExcludeExtensions []string
+	IncludeTypes      []string
+	ExcludeTypes      []string
+	FileTypes         map[string]FileType
+	IncludePath       *regexp.Regexp
+	ExcludePath       *regexp.Regexp
+
+	// SelectionHandler is called for each file and directory found while
+	// recursing into directories with the decision whether it is selected.
+	// It may be called concurrently.
+	SelectionHandler func(Selection)
+
+	// ErrorHandler is called for errors that do not abort the search, e.g. if a
+	// file cannot be opened. It may be called concurrently. If ErrorHandler
+	// is nil, these errors are ignored.
+	ErrorHandler func(error)
+}
+
+// TargetError records an error that occurred while processing a target.
+type TargetError struct {
+	Op     string
+	Target string
+	Err    error
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("%s '%s': %s", e.Op, e.Target, e.Err)
+}
+
+type Match struct {
+	// offset of the start of the match
+	Start int64
+	// offset of the end of the match
+	End int64
+	// offset of the beginning of the first line of the match
+	LineStart int64
+	// offset of the end of the last line of the match
+	LineEnd int64
+	// the match
+	Match string
+	// the match including the non-matched text on the first and last line
+	Line string
+	// the line number of the beginning of the match
+	Lineno int64
+	// the number of bytes of the line before LineStart, only non-zero if the
+	// line exceeds the input buffer and is searched in windows (see
+	// Options.SplitLongLines), Line then holds the part of the window
+	ColumnOffset int64
+	// the index to Options.Patterns (the pattern that produced this match)
+	PatternID int
+	// the context before the match
+	ContextBefore *string
+	// the context after the match
+	ContextAfter *string
+	// the index to the conditions (if this match belongs to a condition)
+	conditionID int
+}
+
+type Matches []Match
+
+func (e Matches) Len() int           { return len(e) }
+func (e Matches) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e Matches) Less(i, j int) bool { return e[i].Start < e[j].Start }
+
+// Result holds the matches found in a target.
+type Result struct {
+	Target  string
+	Matches Matches
+	// if too many matches are found or input is read only from STDIN,
+	// matches are streamed through a channel
+	MatchChan chan Matches
+	Streaming bool
+	IsBinary  bool
+}
+
+// ResultHandler is called for each processed target. It is always called
+// from a single goroutine. If the result is streaming, the handler should
+// read Result.MatchChan, remaining matches are discarded when it returns.
+// Once the context of the search is done, the handler is not called anymore.
+type ResultHandler func(result *Result)
+
+// Searcher searches targets for the patterns given in its Options.
+type Searcher struct {
+	opts               Options
+	regexes            []*regexp.Regexp
+	literals           *literalMatcher
+	foldCase           bool // use Unicode case folding instead of lowercasing the input
+	conditions         []condition
+	conditionExpr      *ConditionExpr
+	blockSize          int
+	archiveDepth       int
+	streamingAllowed   bool
+	streamingThreshold int
+	segmentSize        int64
+	index              *index.Index
+	// indexCandidates holds for each indexed file whether it can contain a match
+	indexCandidates []bool
+}
+
+// searchRun holds the state of a single search.
+type searchRun struct {
+	*Searcher
+	ctx              context.Context
+	handler          ResultHandler
+	filesChan        chan string
+	directoryChan    chan string
+	resultsChan      chan *Result
+	resultsDoneChan  chan struct{}
+	targetsWaitGroup sync.WaitGroup
+	recurseWaitGroup sync.WaitGroup
+	gitignoreCache   *gitignore.GitIgnoreCache
+	// files selected from git indexes, skipped when recursing for untracked files
+	trackedFiles map[string]bool
+	trackedMutex sync.RWMutex
+	// lines added or modified since ChangedSince by file, for ChangedLines
+	changedRanges map[string][]git.LineRange
+	changedMutex  sync.RWMutex
+}
+
+// New returns a Searcher for the given options.
+func New(opts Options) (*Searcher, error) {
+	s := &Searcher{opts: opts}
+	if s.opts.Cores <= 0 {
+		s.opts.Cores = runtime.NumCPU()
+	}
+	if s.opts.GitUntracked {
+		s.opts.GitTracked = true
+	}
+	s.blockSize = opts.BlockSize
+	if s.blockSize == 0 {
+		s.blockSize = DefaultBlockSize
+	}
+	s.archiveDepth = opts.ArchiveDepth
+	if s.archiveDepth == 0 {
+		s.archiveDepth = DefaultArchiveDepth
+	}
+	if s.opts.Multiline && s.blockSize <= InputMultilineWindow {
+		return nil, fmt.Errorf("blocksize must be > %d in multiline mode", InputMultilineWindow)
+	}
+	if s.opts.SplitLongLines && s.blockSize <= LongLineOverlap+longLineLookbehind {
+		return nil, fmt.Errorf("blocksize must be > %d to split long lines", LongLineOverlap+longLineLookbehind)
+	}
+	if s.opts.SplitLongLines && (opts.InvertMatch || opts.ContextBefore > 0 || opts.ContextAfter > 0) {
+		return nil, errors.New("long lines cannot be split with invert match or context lines")
+	}
+	if len(opts.Patterns) == 0 && !opts.TargetsOnly {
+		return nil, errors.New("no pattern given")
+	}
+
+	if opts.IgnoreCase {
+		s.foldCase = opts.UnicodeCase
+		for _, pattern := range opts.Patterns {
+			s.foldCase = s.foldCase || !isASCII(pattern)
+		}
+		for _, c := range opts.Conditions {
+			s.foldCase = s.foldCase || !isASCII(c.Pattern)
+		}
+	}
+
+	s.regexes = make([]*regexp.Regexp, len(opts.Patterns))
+	for i, pattern := range opts.Patterns {
+		re, err := regexp.Compile(s.preparePattern(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse pattern: %s", err)
+		}
+		s.regexes[i] = re
+	}
+	// use the Aho-Corasick matcher for large sets of literal patterns
+	if len(opts.Patterns) >= MinLiteralPatterns {
+		if literals, ok := s.literalPatterns(opts.Patterns); ok {
+			s.literals = newLiteralMatcher(literals, opts.WordRegexp)
+		}
+	}
+	for _, c := range opts.Conditions {
+		cond := condition{Condition: c}
+		pattern, err := s.conditionPattern(&cond)
+		if err != nil {
+			return nil, err
+		}
+		cond.regex, err = regexp.Compile(s.preparePattern(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse condition pattern '%s': %s", c.Pattern, err)
+		}
+		s.conditions = append(s.conditions, cond)
+	}
+	if opts.ConditionExpr != nil {
+		if err := opts.ConditionExpr.validate(len(opts.Conditions)); err != nil {
+			return nil, err
+		}
+		s.conditionExpr = opts.ConditionExpr
+	}
+
+	globs := [][]string{opts.IncludeDirs, opts.ExcludeDirs, opts.IncludeFiles, opts.ExcludeFiles}
+	for _, list := range globs {
+		for _, glob := range list {
+			if _, err := filepath.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("cannot match malformed pattern '%s': %s", glob, err)
+			}
+		}
+	}
+	for _, t := range append(append([]string{}, opts.IncludeTypes...), opts.ExcludeTypes...) {
+		if _, ok := opts.FileTypes[t]; !ok {
+			return nil, fmt.Errorf("file type '%s' is not specified", t)
+		}
+	}
+
+	s.segmentSize = opts.SegmentSize
+	if s.segmentSize == 0 {
+		s.segmentSize = DefaultSegmentSize
+	}
+	if s.opts.Cores < 2 || opts.Multiline || opts.ContextBefore > 0 || opts.ContextAfter > 0 || len(opts.Conditions) > 0 || opts.ChangedLines {
+		s.segmentSize = 0
+	}
+
+	if opts.Index != nil && !opts.InvertMatch && !opts.Zip && !opts.Archives && !opts.TargetsOnly {
+		s.index = opts.Index
+		s.indexCandidates = s.index.Candidates(s.indexQuery())
+	}
+
+	if opts.StreamingThreshold >= 0 {
+		s.streamingAllowed = true
+		s.streamingThreshold = opts.StreamingThreshold
+	}
+	return s, nil
+}
+
+// preparePattern adjusts a pattern to respect the ignore-case, literal and multiline options
+func (s *Searcher) preparePattern(pattern string) string {
+	if s.opts.Literal {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if s.lowercaseInput() {
+		pattern = lowercasePattern(pattern, s.opts.Multiline)
+	}
+	if s.opts.WordRegexp {
+		pattern = `\b` + pattern + `\b`
+	}
+	pattern = "(?m)" + pattern
+	if s.foldCase {
+		pattern = "(?i)" + pattern
+	}
+	if s.opts.Multiline {
+		pattern = "(?s)" + pattern
+	}
+	return pattern
+}
+
+// isASCII checks whether str only contains ASCII characters.
+func isASCII(str string) bool {
+	for i := 0; i < len(str); i++ {
+		if str[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// lowercasePattern adjusts a pattern to match the lowercased input. Only the
+// literals and character classes are changed, so escape sequences like \S keep
+// their meaning. The pattern is parsed with the flags preparePattern sets, so
+// that anchors and dots keep their meaning as well.
+func lowercasePattern(pattern string, multiline bool) string {
+	if strings.IndexFunc(pattern, unicode.IsUpper) < 0 {
+		return pattern
+	}
+	// case folding makes character classes contain both cases of their
+	// letters before they are negated, e.g. [^A-Z] excludes a-z as well
+	flags := syntax.Perl&^syntax.OneLine | syntax.FoldCase
+	if multiline {
+		flags |= syntax.DotNL
+	}
+	re, err := syntax.Parse(pattern, flags)
+	if err != nil {
+		// let the regex compilation report the error
+		return pattern
+	}
+	lowercaseRegexp(re)
+	return re.String()
+}
+
+// lowercaseRegexp replaces the case folded literals of re by their lowercase
+// form, character classes already contain the lowercase letters.
+func lowercaseRegexp(re *syntax.Regexp) {
+	if re.Op == syntax.OpLiteral && re.Flags&syntax.FoldCase != 0 {
+		for i, r := range re.Rune {
+			re.Rune[i] = unicode.ToLower(r)
+		}
+	}
+	re.Flags &^= syntax.FoldCase
+	for _, sub := range re.Sub {
+		lowercaseRegexp(sub)
+	}
+}
+
+// lowercaseInput reports whether the input has to be lowercased before matching.
+func (s *Searcher) lowercaseInput() bool {
+	return s.opts.IgnoreCase && !s.foldCase
+}
+
+// Regexes returns the compiled search patterns.
+func (s *Searcher) Regexes() []*regexp.Regexp {
+	return s.regexes
+}
+
+// reportError passes an error to the error handler.
+func (s *Searcher) reportError(op string, target string, err error) {
+	if s.opts.ErrorHandler != nil {
+		s.opts.ErrorHandler(&TargetError{Op: op, Target: target, Err: err})
+	}
+}
+
+// newRun prepares a new search.
+func (s *Searcher) newRun(ctx context.Context, handler ResultHandler) *searchRun {
+	return &searchRun{
+		Searcher:        s,
+		ctx:             ctx,
+		handler:         handler,
+		filesChan:       make(chan string, 256),
+		directoryChan:   make(chan string, 128),
+		resultsChan:     make(chan *Result, 128),
+		resultsDoneChan: make(chan struct{}),
+		gitignoreCache:  gitignore.NewGitIgnoreCache(),
+		changedRanges:   map[string][]git.LineRange{},
+	}
+}
+
+// handleResults reads resultsChan and calls the result handler.
+func (r *searchRun) handleResults() {
+	for result := range r.resultsChan {
+		if r.ctx.Err() == nil {
+			r.handler(result)
+		}
+		if result.Streaming {
+			for range result.MatchChan {
+			}
+		}
+	}
+	r.resultsDoneChan <- struct{}{}
+}
+
+// Search searches the given targets, which are files, directories, "-" for STDIN
+// or network targets of the form tcp://HOST:PORT (listening for a single connection).
+// The search is aborted when ctx is done, in that case the context's error is returned.
+func (s *Searcher) Search(ctx context.Context, targets []string, handler ResultHandler) error {
+	for _, target := range targets {
+		if target == "-" || netTcpRegex.MatchString(target) || s.revisionSearch() {
+			continue
+		}
+		if _, err := os.Stat(target); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("no such file or directory: %s", target)
+			}
+			return fmt.Errorf("cannot open file or directory: %s", target)
+		}
+	}
+
+	r := s.newRun(ctx, handler)
+	go r.handleResults()
+
+	for i := 0; i < s.opts.Cores; i++ {
+		r.targetsWaitGroup.Add(1)
+		go r.processFileTargets()
+	}
+
+	go r.processDirectories()
+
+	var revisionTargets []string
+targetLoop:
+	for _, target := range targets {
+		switch {
+		case ctx.Err() != nil:
+			break targetLoop
+		case target == "-":
+			r.sendFile("-")
+		case netTcpRegex.MatchString(target):
+			r.targetsWaitGroup.Add(1)
+			go r.processNetworkTarget(target)
+		case s.revisionSearch():
+			revisionTargets = append(revisionTargets, target)
+		default:
+			fileinfo, err := os.Stat(target)
+			if err != nil {
+				s.reportError("cannot open file or directory", target, err)
+				continue
+			}
+			if s.opts.ChangedSince != "" {
+				r.recurseWaitGroup.Add(1)
+				go r.processChangedTarget(target, fileinfo.IsDir())
+			} else if fileinfo.IsDir() && s.opts.GitTracked {
+				r.recurseWaitGroup.Add(1)
+				go r.processGitTarget(target)
+			} else if fileinfo.IsDir() {
+				r.recurseWaitGroup.Add(1)
+				select {
+				case r.directoryChan <- target:
+				case <-ctx.Done():
+					r.recurseWaitGroup.Done()
+				}
+			} else {
+				r.sendFile(target)
+			}
+		}
+	}
+
+	if len(revisionTargets) > 0 {
+		r.targetsWaitGroup.Add(1)
+		go r.processRevisionTargets(revisionTargets)
+	}
+
+	r.recurseWaitGroup.Wait()
+	close(r.directoryChan)
+
+	close(r.filesChan)
+	r.targetsWaitGroup.Wait()
+
+	close(r.resultsChan)
+	<-r.resultsDoneChan
+	return ctx.Err()
+}
+
+// SearchReader searches the data read from reader. The name is used as
+// Result.Target.
+func (s *Searcher) SearchReader(ctx context.Context, reader io.Reader, name string, handler ResultHandler) error {
+	r := s.newRun(ctx, handler)
+	go r.handleResults()
+	dataBuffer := make([]byte, s.blockSize)
+	testBuffer := make([]byte, s.blockSize)
+	fileCtx, cancel := r.fileContext()
+	err := r.processReader(fileCtx, reader, dataBuffer, testBuffer, name)
+	cancel()
+	close(r.resultsChan)
+	<-r.resultsDoneChan
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == context.DeadlineExceeded {
+		err = ErrFileTimeout
+	}
+	return err
+}
+
+// SearchBytes searches the given data. The name is used as Result.Target.
+func (s *Searcher) SearchBytes(ctx context.Context, data []byte, name string, handler ResultHandler) error {
+	return s.SearchReader(ctx, bytes.NewReader(data), name, handler)
+}
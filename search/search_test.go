@@ -0,0 +1,50 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIgnoreCase(t *testing.T) {
+	data := "FOO\nfoo bar\nFoo BAR\nf1o\nF-O\n"
+	tests := []struct {
+		pattern   string
+		multiline bool
+		want      []int64
+	}{
+		{"FOO", false, []int64{1, 2, 3}},
+		// anchors match at line boundaries
+		{"^FOO", false, []int64{1, 2, 3}},
+		{"BAR$", false, []int64{2, 3}},
+		{"^F.O$", false, []int64{1, 4, 5}},
+		// negated classes exclude both cases of their letters
+		{"f[^A-Z]o", false, []int64{4, 5}},
+		{"F[^a-z]O", false, []int64{4, 5}},
+		{"[^A-Z]O$", false, []int64{4, 5}},
+		{`\S+ BAR`, false, []int64{2, 3}},
+		{"BAR.F1O", false, nil},
+		{"BAR.F1O", true, []int64{3}},
+	}
+	for _, test := range tests {
+		opts := Options{Patterns: []string{test.pattern}, IgnoreCase: true, Multiline: test.multiline}
+		got := searchLines(t, opts, data)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q (multiline: %t): matches on lines %v, want %v", test.pattern, test.multiline, got, test.want)
+		}
+	}
+}
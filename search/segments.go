@@ -0,0 +1,258 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"os"
+	"sync"
+)
+
+// Large regular files are split into segments ending on a newline, which are
+// searched concurrently by processReader. The results are merged in order,
+// the offsets and line numbers are adjusted by the start offset and the
+// number of newlines of the preceding segments.
+
+// segmentResult is the result of searching a single segment.
+type segmentResult struct {
+	// result is nil if the target was skipped
+	result *Result
+	// lines is the number of newlines in the segment
+	lines int64
+	err   error
+}
+
+// newlineCounter counts the newlines read from a reader.
+type newlineCounter struct {
+	reader io.Reader
+	lines  int64
+}
+
+func (c *newlineCounter) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.lines += int64(countNewlines(p, n))
+	return n, err
+}
+
+// segments splits a file into segments ending on a newline. It returns the start
+// offsets of the segments followed by the file size, nil if the file is not split.
+func (r *searchRun) segments(infile *os.File, reader io.Reader) []int64 {
+	if _, ok := reader.(*mappedReader); !ok && reader != io.Reader(infile) {
+		return nil
+	}
+	if r.segmentSize <= 0 || infile == os.Stdin {
+		return nil
+	}
+	fileinfo, err := infile.Stat()
+	if err != nil || !fileinfo.Mode().IsRegular() || fileinfo.Size() < 2*r.segmentSize {
+		return nil
+	}
+	size := fileinfo.Size()
+	bounds := []int64{0}
+	buf := make([]byte, r.blockSize)
+	for pos := r.segmentSize; pos < size; {
+		length, _ := infile.ReadAt(buf, pos)
+		newline := bytes.IndexByte(buf[:length], '\n')
+		if newline < 0 {
+			if pos+int64(length) >= size {
+				// the last line is not terminated by a newline
+				break
+			}
+			// the line does not fit into the input buffer, let processReader handle it
+			return nil
+		}
+		start := pos + int64(newline) + 1
+		if start >= size {
+			break
+		}
+		bounds = append(bounds, start)
+		pos = start + r.segmentSize
+	}
+	if len(bounds) < 2 {
+		return nil
+	}
+	return append(bounds, size)
+}
+
+// processSegments searches the segments of a file concurrently and sends the
+// merged result. If the file is memory-mapped, mapped holds its data.
+func (r *searchRun) processSegments(ctx context.Context, infile *os.File, mapped []byte, bounds []int64, target string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var workersWaitGroup sync.WaitGroup
+	defer workersWaitGroup.Wait()
+	defer cancel()
+
+	count := len(bounds) - 1
+	workers := r.opts.Cores
+	if workers > count {
+		workers = count
+	}
+	results := make([]chan segmentResult, count)
+	for i := range results {
+		results[i] = make(chan segmentResult, 1)
+	}
+	// tokens limits the number of segments searched ahead of the merged one
+	tokens := make(chan struct{}, 2*workers)
+	segmentChan := make(chan int)
+	go func() {
+		defer close(segmentChan)
+		for i := 0; i < count; i++ {
+			select {
+			case tokens <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case segmentChan <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		workersWaitGroup.Add(1)
+		go func() {
+			defer workersWaitGroup.Done()
+			data := make([]byte, r.blockSize)
+			testBuffer := make([]byte, r.blockSize)
+			for i := range segmentChan {
+				if mapped != nil {
+					var res segmentResult
+					res.err = guardMapped(func() error {
+						res = r.searchSegment(ctx, newMappedReader(mapped[bounds[i]:bounds[i+1]]), i == 0, data, testBuffer, target)
+						return res.err
+					})
+					results[i] <- res
+					continue
+				}
+				length := bounds[i+1] - bounds[i]
+				if i == count-1 {
+					// the last segment is read to the end, even if the file grows
+					length = math.MaxInt64 - bounds[i]
+				}
+				results[i] <- r.searchSegment(ctx, io.NewSectionReader(infile, bounds[i], length), i == 0, data, testBuffer, target)
+			}
+		}()
+	}
+
+	var (
+		matches         Matches
+		matchChan       chan Matches
+		matchCount      int64
+		lines           int64
+		resultIsBinary  bool
+		resultStreaming bool
+	)
+	defer func() {
+		if resultStreaming {
+			close(matchChan)
+		}
+	}()
+	for i := 0; i < count; i++ {
+		var res segmentResult
+		select {
+		case res = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		<-tokens
+		if res.err != nil {
+			return res.err
+		}
+		if res.result == nil {
+			// binary file skipped
+			return nil
+		}
+		if i == 0 {
+			resultIsBinary = res.result.IsBinary
+		}
+		newMatches := res.result.Matches
+		for j := range newMatches {
+			m := &newMatches[j]
+			m.Start += bounds[i]
+			m.End += bounds[i]
+			m.LineStart += bounds[i]
+			m.LineEnd += bounds[i]
+			m.Lineno += lines
+		}
+		lines += res.lines
+
+		if len(newMatches) > 0 && r.opts.FileMatchOnly {
+			r.resultsChan <- &Result{Target: target, Matches: newMatches[:1], IsBinary: resultIsBinary}
+			return nil
+		}
+		if r.opts.Limit != 0 && matchCount+int64(len(newMatches)) > r.opts.Limit {
+			newMatches = newMatches[:r.opts.Limit-matchCount]
+		}
+		matchCount += int64(len(newMatches))
+		if len(newMatches) > 0 {
+			if resultStreaming {
+				matchChan <- newMatches
+			} else {
+				matches = append(matches, newMatches...)
+				if len(matches) > r.streamingThreshold && r.streamingAllowed {
+					resultStreaming = true
+					matchChan = make(chan Matches, 16)
+					r.resultsChan <- &Result{Target: target, Matches: matches, Streaming: true, MatchChan: matchChan, IsBinary: resultIsBinary}
+				}
+			}
+		}
+		if r.opts.Limit != 0 && matchCount >= r.opts.Limit {
+			break
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !resultStreaming {
+		r.resultsChan <- &Result{Target: target, Matches: matches, Streaming: false, IsBinary: resultIsBinary}
+	}
+	return nil
+}
+
+// searchSegment searches a segment with processReader. Matches are reported
+// relative to the start of the segment.
+func (r *searchRun) searchSegment(ctx context.Context, reader io.Reader, first bool, data []byte, testBuffer []byte, target string) segmentResult {
+	s := *r.Searcher
+	s.streamingAllowed = false
+	if !first {
+		// binary files are detected by the beginning of the file
+		s.opts.BinarySkip = false
+	}
+	segment := &searchRun{Searcher: &s, ctx: r.ctx, resultsChan: make(chan *Result, 1)}
+	var res segmentResult
+	var counter *newlineCounter
+	if m, ok := reader.(*mappedReader); ok && r.opts.LineNumbers {
+		res.lines = int64(countNewlines(m.data, len(m.data)))
+	} else if r.opts.LineNumbers {
+		counter = &newlineCounter{reader: reader}
+		reader = counter
+	}
+
+	res.err = segment.processReader(ctx, reader, data, testBuffer, target)
+	select {
+	case res.result = <-segment.resultsChan:
+	default:
+	}
+	if counter != nil {
+		res.lines = counter.lines
+	}
+	return res
+}
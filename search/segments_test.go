@@ -0,0 +1,153 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTempFile writes data to a file in dir and returns its path.
+func writeTempFile(t *testing.T, dir string, data string) string {
+	path := filepath.Join(dir, "input")
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sift-segments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lines := strings.Repeat("abcdefg\n", 10)
+	tests := []struct {
+		data        string
+		segmentSize int64
+		blockSize   int
+		want        []int64
+	}{
+		{lines, 16, 0, []int64{0, 24, 48, 72, 80}},
+		{lines, 40, 0, []int64{0, 48, 80}},
+		// no segment starts at the end of the file
+		{lines, 36, 0, []int64{0, 40, 80}},
+		{lines + "no newline", 40, 0, []int64{0, 48, 90}},
+		// the file is smaller than two segments
+		{lines, 41, 0, nil},
+		{lines, -1, 0, nil},
+		// a line does not fit into the input buffer
+		{strings.Repeat("x", 100) + "\n" + lines, 10, 16, nil},
+		{strings.Repeat("x", 100) + "\n" + lines, 10, 128, []int64{0, 101, 117, 133, 149, 165, 181}},
+	}
+	for _, test := range tests {
+		s, err := New(Options{Patterns: []string{"x"}, Cores: 2, SegmentSize: test.segmentSize, BlockSize: test.blockSize})
+		if err != nil {
+			t.Fatalf("New: %s", err)
+		}
+		r := s.newRun(context.Background(), nil)
+		infile, err := os.Open(writeTempFile(t, dir, test.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := r.segments(infile, infile)
+		infile.Close()
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("segments(%q, segment size %d) = %v, want %v", test.data, test.segmentSize, got, test.want)
+		}
+		for i := 1; i < len(got)-1; i++ {
+			if test.data[got[i]-1] != '\n' || got[i]-got[i-1] < test.segmentSize {
+				t.Errorf("segments(%q, segment size %d): invalid bound %d", test.data, test.segmentSize, got[i])
+			}
+		}
+	}
+}
+
+// searchFile searches a single file and returns the matches found.
+func searchFile(t *testing.T, opts Options, path string) Matches {
+	s, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	var matches Matches
+	err = s.Search(context.Background(), []string{path}, func(result *Result) {
+		matches = append(matches, result.Matches...)
+		if result.Streaming {
+			for m := range result.MatchChan {
+				matches = append(matches, m...)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("Search: %s", err)
+	}
+	return matches
+}
+
+func TestSegmentedSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sift-segments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var data strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&data, "line %d: %s\n", i, strings.Repeat("ab", i%7))
+	}
+	// the last line is not terminated by a newline
+	data.WriteString("line end: ab")
+	path := writeTempFile(t, dir, data.String())
+
+	tests := []Options{
+		{Patterns: []string{"ab"}},
+		{Patterns: []string{`^line \d*7:`}},
+		{Patterns: []string{"abab"}, InvertMatch: true},
+		{Patterns: []string{"line 1"}, Limit: 25},
+		{Patterns: []string{"ab$"}, StreamingThreshold: 10},
+	}
+	for _, opts := range tests {
+		opts.LineNumbers = true
+		opts.Cores = 4
+		if opts.StreamingThreshold == 0 {
+			opts.StreamingThreshold = -1
+		}
+		opts.SegmentSize = -1
+		want := searchFile(t, opts, path)
+		if len(want) == 0 {
+			t.Fatalf("%q: no matches", opts.Patterns)
+		}
+		opts.SegmentSize = 1000
+		got := searchFile(t, opts, path)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: segmented search found %d matches, want %d", opts.Patterns, len(got), len(want))
+			for i := 0; i < len(got) && i < len(want); i++ {
+				if !reflect.DeepEqual(got[i], want[i]) {
+					t.Errorf("first difference: %+v, want %+v", got[i], want[i])
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/svent/sift/gitignore"
+)
+
+// Selection describes the decision whether a file or directory
+// found while recursing into a directory is selected.
+type Selection struct {
+	Path     string
+	IsDir    bool
+	Selected bool
+	// Filter is the name of the option in Options that decided,
+	// e.g. "ExcludeDirs". It is empty if no option excluded the path.
+	Filter string
+	// Reason describes the decision, e.g. the pattern that matched
+	// or the .gitignore file and line.
+	Reason string
+}
+
+// filterDecision describes the option excluding a file or directory.
+// The zero value is used for selected files and directories.
+type filterDecision struct {
+	// the name of the option in Options
+	filter string
+	// the matching value of the option, e.g. a pattern
+	value string
+	// details like the pattern of a file type
+	detail string
+}
+
+// selection returns the Selection for the entry at path. The description of
+// the decision is only built here, to keep the selection itself fast.
+func (r *searchRun) selection(path string, fi os.FileInfo, selected bool, d filterDecision, gic *gitignore.Checker) Selection {
+	sel := Selection{Path: path, IsDir: fi.IsDir(), Selected: selected, Filter: d.filter}
+	switch d.filter {
+	case "":
+		sel.Reason = d.value
+	case "Recursive":
+		sel.Reason = "not recursing into directories"
+	case "ExcludeDirs":
+		sel.Reason = fmt.Sprintf("directory name matches excluded pattern %q", d.value)
+	case "IncludeDirs":
+		sel.Reason = fmt.Sprintf("directory name matches none of the patterns %q", r.opts.IncludeDirs)
+	case "FollowSymlinks":
+		sel.Reason = "symlinks are not followed"
+	case "ExcludePath":
+		sel.Reason = fmt.Sprintf("path matches excluded pattern %q", d.value)
+	case "IncludePath":
+		sel.Reason = fmt.Sprintf("path does not match pattern %q", d.value)
+	case "ExcludeExtensions":
+		sel.Reason = fmt.Sprintf("extension %q is excluded", d.value)
+	case "IncludeExtensions":
+		sel.Reason = fmt.Sprintf("extension is none of %q", r.opts.IncludeExtensions)
+	case "ExcludeFiles":
+		sel.Reason = fmt.Sprintf("file name matches excluded pattern %q", d.value)
+	case "IncludeFiles":
+		sel.Reason = fmt.Sprintf("file name matches none of the patterns %q", r.opts.IncludeFiles)
+	case "ExcludeTypes":
+		sel.Reason = fmt.Sprintf("file type %q is excluded (%s)", d.value, d.detail)
+	case "IncludeTypes":
+		sel.Reason = fmt.Sprintf("file type is none of %q", r.opts.IncludeTypes)
+	case "Git", "GitUntracked":
+		switch {
+		case d.detail == "repository":
+			sel.Reason = "separate git repository"
+		case d.value == gitignore.GitFoldername:
+			sel.Reason = "git directory"
+		case gitignore.IsIgnoreFile(d.value):
+			sel.Reason = "ignore file"
+		}
+	}
+	// describe the ignore pattern excluding the path or including it again
+	if sel.Reason == "" && gic != nil {
+		if m := gic.Match(path, fi); m != nil {
+			verb := "ignored"
+			if !m.Ignored {
+				verb = "included again"
+			}
+			sel.Reason = fmt.Sprintf("%s by %s:%d: %s", verb, m.File, m.Line, m.Pattern)
+		}
+	}
+	return sel
+}
+
+// Explain returns the decisions of the filters for path and the directories
+// leading to it when searching target, starting with the first directory
+// below target. Files given as target are always selected. A skipped
+// directory is the last entry, as its contents are never visited.
+func (s *Searcher) Explain(target string, path string) ([]Selection, error) {
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return nil, err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if absPath == absTarget {
+		return []Selection{{Path: path, IsDir: fi.IsDir(), Selected: true, Reason: "given as target"}}, nil
+	}
+	rel, err := filepath.Rel(absTarget, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%s is not within %s", path, target)
+	}
+
+	r := s.newRun(context.Background(), nil)
+	var selections []Selection
+	dirname := target
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		var gic *gitignore.Checker
+		if s.opts.Git || s.opts.GitUntracked {
+			gic = gitignore.NewCheckerWithCache(r.gitignoreCache)
+			if err := gic.LoadBasePath(dirname); err != nil {
+				return nil, err
+			}
+		}
+		entry := filepath.Join(dirname, name)
+		fi, err := os.Lstat(entry)
+		if err != nil {
+			return nil, err
+		}
+		selectedPath, _, decision := r.selectEntry(dirname, fi, gic)
+		selections = append(selections, r.selection(entry, fi, selectedPath != "", decision, gic))
+		if selectedPath == "" {
+			break
+		}
+		dirname = entry
+	}
+	return selections, nil
+}
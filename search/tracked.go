@@ -0,0 +1,141 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/svent/sift/git"
+)
+
+// processGitTarget sends the files of the directory target tracked in the
+// index of its git repository on filesChan. With GitUntracked, the target is
+// recursed into afterwards to select the untracked files.
+func (r *searchRun) processGitTarget(target string) {
+	defer r.recurseWaitGroup.Done()
+	repo, err := git.Find(target)
+	if err != nil {
+		r.reportError("cannot open git repository", target, err)
+		return
+	}
+	entries, err := repo.Index()
+	if err != nil {
+		r.reportError("cannot read git index", target, err)
+		return
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		r.reportError("cannot open git repository", target, err)
+		return
+	}
+	prefix, err := filepath.Rel(repo.Root, absTarget)
+	if err != nil {
+		r.reportError("cannot open git repository", target, err)
+		return
+	}
+	prefix = filepath.ToSlash(prefix) + "/"
+	if prefix == "./" {
+		prefix = ""
+	}
+
+	var tracked map[string]bool
+	if r.opts.GitUntracked {
+		tracked = make(map[string]bool, len(entries))
+	}
+	dirs := map[string]bool{filepath.Clean(target): true}
+	for i, e := range entries {
+		if r.ctx.Err() != nil {
+			return
+		}
+		// files with merge conflicts have an entry per stage
+		if i > 0 && entries[i-1].Path == e.Path {
+			continue
+		}
+		if !strings.HasPrefix(e.Path, prefix) {
+			continue
+		}
+		path := filepath.Join(target, filepath.FromSlash(e.Path[len(prefix):]))
+		if tracked != nil {
+			tracked[path] = true
+		}
+		mode := e.Mode & git.ModeTypeMask
+		if e.SkipWorktree || (mode != git.ModeRegular && mode != git.ModeSymlink) {
+			continue
+		}
+		dirname := filepath.Dir(path)
+		if !r.selectTrackedDir(dirname, dirs) {
+			continue
+		}
+		fi, err := os.Lstat(path)
+		if err != nil {
+			// deleted in the work tree
+			continue
+		}
+		selected, isDir, decision := r.selectEntry(dirname, fi, nil)
+		if r.opts.SelectionHandler != nil {
+			r.opts.SelectionHandler(r.selection(path, fi, selected != "", decision, nil))
+		}
+		if selected != "" && !isDir {
+			r.sendFile(selected)
+		}
+	}
+
+	if tracked != nil {
+		r.trackedMutex.Lock()
+		if r.trackedFiles == nil {
+			r.trackedFiles = tracked
+		} else {
+			for path := range tracked {
+				r.trackedFiles[path] = true
+			}
+		}
+		r.trackedMutex.Unlock()
+		r.enqueueDirectory(target)
+	}
+}
+
+// selectTrackedDir checks whether the directories from a git target down to
+// dirname are selected by the directory options. The decisions are cached in dirs.
+func (r *searchRun) selectTrackedDir(dirname string, dirs map[string]bool) bool {
+	if selected, ok := dirs[dirname]; ok {
+		return selected
+	}
+	parent := filepath.Dir(dirname)
+	selected := false
+	if parent != dirname && r.selectTrackedDir(parent, dirs) {
+		if fi, err := os.Lstat(dirname); err == nil {
+			path, _, decision := r.selectEntry(parent, fi, nil)
+			if r.opts.SelectionHandler != nil {
+				r.opts.SelectionHandler(r.selection(dirname, fi, path != "", decision, nil))
+			}
+			selected = path != ""
+		}
+	}
+	dirs[dirname] = selected
+	return selected
+}
+
+// isTracked checks whether path was selected from a git index.
+func (r *searchRun) isTracked(path string) bool {
+	if !r.opts.GitUntracked {
+		return false
+	}
+	r.trackedMutex.RLock()
+	defer r.trackedMutex.RUnlock()
+	return r.trackedFiles[path]
+}
@@ -0,0 +1,92 @@
+// sift
+// Copyright (C) 2014-2016 Sven Taute
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/svent/sift/search"
+)
+
+func TestExplainWithPattern(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sift-explain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"plain.txt", "main.go"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("needle\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	savedOptions, savedOutput, savedSearcher := options, global.outputFile, global.searcher
+	defer func() {
+		options, global.outputFile, global.searcher = savedOptions, savedOutput, savedSearcher
+	}()
+
+	tests := []struct {
+		path     string
+		args     []string
+		patterns []string
+		selected bool
+		output   string
+	}{
+		{"plain.txt", []string{"needle", dir}, []string{"needle"}, false, "plain.txt: skipped by --ext"},
+		{"main.go", []string{"needle", dir}, []string{"needle"}, true, "main.go: selected"},
+		{"main.go", []string{}, nil, true, "main.go: selected"},
+	}
+	for _, test := range tests {
+		options = Options{}
+		options.LoadDefaults()
+		options.IncludeExtensions = "go"
+		options.Explain = filepath.Join(dir, test.path)
+		patterns, targets, err := patternFromArgs(nil, test.args)
+		if err != nil {
+			t.Fatalf("patternFromArgs(%q): %s", test.args, err)
+		}
+		if !reflect.DeepEqual(patterns, test.patterns) {
+			t.Errorf("patternFromArgs(%q) returned patterns %q, want %q", test.args, patterns, test.patterns)
+		}
+		if len(targets) == 0 {
+			targets = []string{dir}
+		}
+		if err := options.Apply(patterns, targets); err != nil {
+			t.Fatalf("Apply: %s", err)
+		}
+		global.searcher, err = search.New(options.searchOptions(patterns))
+		if err != nil {
+			t.Fatalf("New: %s", err)
+		}
+		var output bytes.Buffer
+		global.outputFile = &output
+		selected, err := explainSelection(options.Explain, targets)
+		if err != nil {
+			t.Errorf("explainSelection(%s, %q): %s", test.path, targets, err)
+			continue
+		}
+		if selected != test.selected || !strings.Contains(output.String(), test.output) {
+			t.Errorf("explainSelection(%s, %q) = %t with output %q, want %t with %q",
+				test.path, targets, selected, output.String(), test.selected, test.output)
+		}
+	}
+}